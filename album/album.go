@@ -3,6 +3,7 @@ package album
 import (
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -40,6 +41,30 @@ type SpotifyAlbum struct {
 	Uri        string          `json:"uri"`
 	Artists    []SpotifyArtist `json:"artists"`
 	Popularity int
+	// The fields below are only available on the full album object, see spotify.GetAlbums.
+	Label      string
+	Copyrights []SpotifyCopyright
+	UPC        string
+	Tracks     []SpotifyTrack
+}
+
+type SpotifyCopyright struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type SpotifyTrack struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	TrackNumber  int    `json:"track_number"`
+	DiscNumber   int    `json:"disc_number"`
+	DurationMS   int    `json:"duration_ms"`
+	Explicit     bool   `json:"explicit"`
+	PreviewURL   string `json:"preview_url"`
+	Uri          string `json:"uri"`
+	ExternalUrls struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
 }
 
 type SpotifyAlbums struct {
@@ -115,13 +140,28 @@ type DeezerArtist struct {
 }
 
 type Album struct {
-	AlbumArt   string
+	AlbumArt string
+	// Cover is the file name of the resized cover sent inline with the newsletter, see the cover package.
+	Cover      string
 	ArtistName string
 	AlbumName  string
 	Genres     []string
-	Tidal      TidalAlbum
-	Spotify    SpotifyAlbum
-	Deezer     DeezerAlbum
+	// Score orders the albums of the newsletter, the highest first, see RankByPopularity.
+	Score   float64
+	Tidal   TidalAlbum
+	Spotify SpotifyAlbum
+	Deezer  DeezerAlbum
+	// Links replace the URLs of the platforms, keyed by platform, e.g. so the clicks go
+	// through a tracker first. Only the platforms the album was found on are replaced.
+	Links map[string]string `json:"-"`
+	// Feedback are the links telling whether albums like this one are welcome, empty
+	// when there is nowhere to send the answer.
+	Feedback Feedback `json:"-"`
+}
+
+type Feedback struct {
+	More string
+	Less string
 }
 
 func remove(a []Album, i int) []Album {
@@ -148,17 +188,47 @@ func RemoveCopies(albums []Album) []Album {
 	return albums
 }
 
+// RankByPopularity scores the albums with their Spotify popularity and sorts them by score.
 func RankByPopularity(albums []Album) []Album {
+	for i := range albums {
+		albums[i].Score = float64(albums[i].Spotify.Popularity)
+	}
 	sort.Slice(albums, func(i, j int) bool {
-		return albums[j].Spotify.Popularity < albums[i].Spotify.Popularity
+		return albums[j].Score < albums[i].Score
 	})
 	return albums
 }
 
+// Key identifies the album across runs with its ID on a platform, e.g. spotify:<id>. It
+// falls back to Tidal then Deezer, and is empty when the album was found on none of them.
+func (album Album) Key() string {
+	switch {
+	case album.Spotify.Id != "":
+		return "spotify:" + album.Spotify.Id
+	case album.Tidal.ID != "":
+		return "tidal:" + album.Tidal.ID
+	case album.Deezer.ID != 0:
+		return fmt.Sprintf("deezer:%d", album.Deezer.ID)
+	}
+	return ""
+}
+
+// ReleaseDate is the release date given by Spotify, or by Tidal when the album isn't on
+// Spotify. Its precision varies, it can be a year only.
+func (album Album) ReleaseDate() string {
+	if album.Spotify.ReleaseDate != "" {
+		return album.Spotify.ReleaseDate
+	}
+	return album.Tidal.ReleaseDate
+}
+
 func (album Album) GetTidalURL() (string, error) {
 	if album.Tidal.ID == "" {
 		return "", nil
 	}
+	if link, ok := album.Links["Tidal"]; ok {
+		return link, nil
+	}
 	return fmt.Sprintf("https://listen.tidal.com/album/%s", album.Tidal.ID), nil
 }
 
@@ -166,6 +236,9 @@ func (album Album) GetSpotifyURL() (string, error) {
 	if album.Spotify.ExternalUrls.Spotify == "" {
 		return "", nil
 	}
+	if link, ok := album.Links["Spotify"]; ok {
+		return link, nil
+	}
 	return album.Spotify.ExternalUrls.Spotify, nil
 }
 
@@ -173,5 +246,37 @@ func (album Album) GetDeezerURL() (string, error) {
 	if album.Deezer.Link == "" {
 		return "", nil
 	}
+	if link, ok := album.Links["Deezer"]; ok {
+		return link, nil
+	}
 	return album.Deezer.Link, nil
 }
+
+// Duration is the total length of the album, computed from the Spotify track list.
+func (album Album) Duration() time.Duration {
+	var total time.Duration
+	for _, track := range album.Spotify.Tracks {
+		total += time.Duration(track.DurationMS) * time.Millisecond
+	}
+	return total
+}
+
+// Explicit reports whether at least one track of the album is explicit.
+func (album Album) Explicit() bool {
+	for _, track := range album.Spotify.Tracks {
+		if track.Explicit {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSingle reports whether the release is an EP or a single rather than a full album.
+// Spotify files EPs as singles, Deezer tells them apart.
+func (album Album) IsSingle() bool {
+	switch album.Deezer.RecordType {
+	case "ep", "single":
+		return true
+	}
+	return album.Spotify.AlbumType == "single"
+}
@@ -0,0 +1,212 @@
+// Package archive publishes the past newsletters as a static web site, an index plus one
+// page per week, and an Atom feed with an entry per week.
+package archive
+
+import (
+	"bytes"
+	"encoding/xml"
+	htmltemplate "html/template"
+	"newmusicrelease/history"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var cidPattern = regexp.MustCompile(`cid:([^"'\s)>]+)`)
+
+// imagesDir is where the images referenced with cid: are copied, next to the pages.
+const imagesDir = "images"
+
+type Site struct {
+	// Dir is where the site is written.
+	Dir   string
+	Title string
+	// BaseURL is the address the site is published at, so the feed can link to it. The
+	// links are relative when empty.
+	BaseURL string
+	// FeedEntries is how many weeks the feed holds, the most recent ones.
+	FeedEntries int
+	// Template holds the "index", "week" and "entry" templates, see theme.Theme.Archive.
+	Template *htmltemplate.Template
+	// Image returns the content of an image referenced with cid: in the templates.
+	Image func(name string) ([]byte, error)
+
+	images map[string]bool
+}
+
+type indexPage struct {
+	Title string
+	Weeks []weekLink
+}
+
+type weekLink struct {
+	history.Week
+	Page string
+}
+
+type weekPage struct {
+	Title string
+	history.Week
+	// Newer and Older are the pages of the weeks around this one, empty at the ends.
+	Newer string
+	Older string
+}
+
+// Build writes the pages and the feed of the weeks, which are expected the most recent first.
+func (s *Site) Build(weeks []history.Week) error {
+	s.images = make(map[string]bool)
+
+	err := os.MkdirAll(filepath.Join(s.Dir, imagesDir), 0o755)
+	if err != nil {
+		return err
+	}
+
+	index := indexPage{Title: s.Title}
+	for _, w := range weeks {
+		index.Weeks = append(index.Weeks, weekLink{Week: w, Page: page(w)})
+	}
+	err = s.render("index.html", "index", index)
+	if err != nil {
+		return err
+	}
+
+	for i, w := range weeks {
+		p := weekPage{Title: s.Title, Week: w}
+		if i > 0 {
+			p.Newer = page(weeks[i-1])
+		}
+		if i < len(weeks)-1 {
+			p.Older = page(weeks[i+1])
+		}
+		err = s.render(page(w), "week", p)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.feed(weeks)
+	if err != nil {
+		return err
+	}
+
+	for name := range s.images {
+		data, err := s.Image(name)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(s.Dir, imagesDir, name), data, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func page(w history.Week) string {
+	return w.ID() + ".html"
+}
+
+// execute renders the template and points its cid: images to the images directory,
+// prefixed with base.
+func (s *Site) execute(name string, data any, base string) (string, error) {
+	var out bytes.Buffer
+	err := s.Template.ExecuteTemplate(&out, name, data)
+	if err != nil {
+		return "", err
+	}
+
+	for _, match := range cidPattern.FindAllStringSubmatch(out.String(), -1) {
+		s.images[match[1]] = true
+	}
+	return cidPattern.ReplaceAllString(out.String(), base+imagesDir+"/$1"), nil
+}
+
+func (s *Site) render(file string, name string, data any) error {
+	html, err := s.execute(name, data, "")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, file), []byte(html), 0o644)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// feed writes feed.xml. Its ids don't depend on BaseURL so moving the site doesn't show
+// every week as new in feed readers.
+func (s *Site) feed(weeks []history.Week) error {
+	base := s.BaseURL
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	feed := atomFeed{
+		Title:  s.Title,
+		ID:     "urn:newmusicrelease:archive",
+		Author: atomAuthor{Name: s.Title},
+		Links: []atomLink{
+			{Href: base + "feed.xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "index.html", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	if len(weeks) > 0 {
+		feed.Updated = weeks[0].Date.Format(time.RFC3339)
+	} else {
+		feed.Updated = time.Now().Format(time.RFC3339)
+	}
+
+	for i, w := range weeks {
+		if s.FeedEntries > 0 && i >= s.FeedEntries {
+			break
+		}
+		content, err := s.execute("entry", weekPage{Title: s.Title, Week: w}, base)
+		if err != nil {
+			return err
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   "New Music Friday – " + w.Date.Format("January 2, 2006"),
+			ID:      "urn:newmusicrelease:week:" + w.ID(),
+			Updated: w.Date.Format(time.RFC3339),
+			Link:    atomLink{Href: base + page(w), Rel: "alternate", Type: "text/html"},
+			Content: atomContent{Type: "html", Body: strings.TrimSpace(content)},
+		})
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, "feed.xml"), append([]byte(xml.Header), data...), 0o644)
+}
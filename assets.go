@@ -0,0 +1,37 @@
+// Package newmusicrelease embeds the default newsletter templates and platform logos so
+// the binary works whatever directory it is launched from.
+package newmusicrelease
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+//go:embed newsletter.tmpl newsletter.txt.tmpl style.css archive.tmpl
+var templates embed.FS
+
+//go:embed logo/*/*-64.png
+var logos embed.FS
+
+// Templates returns the directory holding newsletter.tmpl, newsletter.txt.tmpl, style.css
+// and archive.tmpl, the embedded ones when dir is empty.
+func Templates(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return templates
+}
+
+// Logos returns the directory holding the images referenced with cid: in the templates,
+// one sub-directory per platform. The embedded logos are used when dir is empty.
+func Logos(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(logos, "logo")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
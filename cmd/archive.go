@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/fs"
+	"newmusicrelease/archive"
+	"newmusicrelease/history"
+	"newmusicrelease/theme"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// buildArchive writes the web archive and the Atom feed of every week in the history to
+// outputDir, with the default theme.
+func buildArchive(outputDir string) error {
+	viper.SetDefault("archive.title", "New Music Friday")
+	viper.SetDefault("archive.feed_entries", 20)
+
+	weeks, err := history.FromConfig().Weeks()
+	if err != nil {
+		return err
+	}
+
+	t, err := theme.Load(theme.Default)
+	if err != nil {
+		return err
+	}
+	tmpl, err := t.Archive()
+	if err != nil {
+		return err
+	}
+
+	site := archive.Site{
+		Dir:         outputDir,
+		Title:       viper.GetString("archive.title"),
+		BaseURL:     viper.GetString("archive.base_url"),
+		FeedEntries: viper.GetInt("archive.feed_entries"),
+		Template:    tmpl,
+		Image: func(name string) ([]byte, error) {
+			dir, filename, err := findImage(inlineImages(t), name)
+			if err != nil {
+				return nil, err
+			}
+			return fs.ReadFile(dir, filename)
+		},
+	}
+	err = site.Build(weeks)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("output_dir", outputDir).Int("weeks", len(weeks)).Msg("Archive built")
+	return nil
+}
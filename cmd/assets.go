@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"mime"
+	"newmusicrelease/album"
+	"newmusicrelease/cover"
+	"newmusicrelease/provider"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/theme"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+var cidPattern = regexp.MustCompile(`cid:([^"'\s)>]+)`)
+
+// inlineImages are the directories where the images referenced with cid: are looked for:
+// the assets of the theme and the album covers.
+func inlineImages(t *theme.Theme) []fs.FS {
+	return []fs.FS{
+		t.Assets,
+		os.DirFS(cover.FromConfig().CacheDir),
+	}
+}
+
+// findImage looks for name at the root of each directory or in one of their sub-directories.
+func findImage(dirs []fs.FS, name string) (fs.FS, string, error) {
+	for _, dir := range dirs {
+		if _, err := fs.Stat(dir, name); err == nil {
+			return dir, name, nil
+		}
+		matches, err := fs.Glob(dir, path.Join("*", name))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(matches) > 0 {
+			return dir, matches[0], nil
+		}
+	}
+	return nil, "", fmt.Errorf("inline image %q is referenced but can't be found", name)
+}
+
+// attachInlineImages attaches every image referenced with cid: in the HTML body of e.
+func attachInlineImages(e *email.Email, dirs []fs.FS) error {
+	attached := make(map[string]bool)
+	for _, match := range cidPattern.FindAllSubmatch(e.HTML, -1) {
+		name := string(match[1])
+		if attached[name] {
+			continue
+		}
+
+		dir, filename, err := findImage(dirs, name)
+		if err != nil {
+			return err
+		}
+		f, err := dir.Open(filename)
+		if err != nil {
+			return err
+		}
+		attachment, err := e.Attach(f, name, mime.TypeByExtension(path.Ext(name)))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		attachment.HTMLRelated = true
+		attached[name] = true
+	}
+	return nil
+}
+
+// themes keeps the themes loaded during the run, by name.
+type themes map[string]*theme.Theme
+
+func (ts themes) get(name string) (*theme.Theme, error) {
+	if t, ok := ts[name]; ok {
+		return t, nil
+	}
+	t, err := theme.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	ts[name] = t
+	return t, nil
+}
+
+// checkThemes checks the default theme and the themes of the subscribers with
+// checkInlineImages.
+func checkThemes(ts themes, subscribers []subscriber.Subscriber) error {
+	names := []string{theme.Default}
+	for _, s := range subscribers {
+		names = append(names, s.Theme)
+	}
+	return checkInlineImages(ts, names)
+}
+
+// checkInlineImages renders each theme with an album available on every platform and
+// makes sure every cid: image it references can be attached, so a broken theme is caught
+// before fetching anything.
+func checkInlineImages(ts themes, names []string) error {
+	sample := album.Album{AlbumName: "Sample", ArtistName: "Sample", Genres: []string{"sample"}}
+	sample.Tidal.ID = "sample"
+	sample.Spotify.ExternalUrls.Spotify = "https://open.spotify.com"
+	sample.Deezer.Link = "https://www.deezer.com"
+	newsletter := Newsletter{
+		Date:     time.Now(),
+		Albums:   []album.Album{sample},
+		Sections: []Section{{Key: sectionWildcards, Title: "Wildcards", Albums: []album.Album{sample}}},
+		Playlists: []provider.Playlist{
+			{Platform: provider.Spotify, Name: "Sample", URL: "https://open.spotify.com"},
+		},
+	}
+
+	for _, name := range names {
+		t, err := ts.get(name)
+		if err != nil {
+			return err
+		}
+		_, err = buildEmail(t, newsletter, "sample@example.com")
+		if err != nil {
+			return fmt.Errorf("theme %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"newmusicrelease/deezer"
+	"newmusicrelease/provider"
+	"newmusicrelease/tidal"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// authorize runs the OAuth flow letting the app manage the playlists of the user on
+// platform: it prints the page where they allow it, waits for the platform to redirect
+// them to addr, and saves the tokens in the config file. The redirect URI,
+// http://<addr>/callback, has to be registered in the settings of the app.
+func authorize(platform string, addr string) error {
+	redirectURI := "http://" + addr + "/callback"
+
+	codeVerifier, err := randomString()
+	if err != nil {
+		return err
+	}
+	// The callback only accepts the code of the authorization started here
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	var authURL string
+	var exchange func(code string) error
+	switch strings.ToLower(platform) {
+	case strings.ToLower(provider.Deezer):
+		authURL = deezer.AuthURL(redirectURI, state)
+		exchange = deezer.Exchange
+	case strings.ToLower(provider.Tidal):
+		authURL = tidal.AuthURL(redirectURI, codeVerifier, state)
+		exchange = func(code string) error {
+			return tidal.Exchange(code, redirectURI, codeVerifier)
+		}
+	default:
+		return fmt.Errorf("no authorization needed for %q, try deezer or tidal", platform)
+	}
+
+	done := make(chan error, 1)
+	finish := func(err error) {
+		// Only the first outcome is waited for
+		select {
+		case done <- err:
+		default:
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(state)) != 1 {
+			// Not the redirect of our authorization, keep waiting for it
+			http.Error(w, "Unexpected authorization, start it again from the link in the terminal.", http.StatusBadRequest)
+			log.Warn().Msg("authorization callback with an unexpected state ignored")
+			return
+		}
+
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			// Deezer says why with error_reason, Tidal with error
+			reason := r.URL.Query().Get("error_reason") + r.URL.Query().Get("error")
+			http.Error(w, "Authorization denied: "+reason, http.StatusBadRequest)
+			finish(fmt.Errorf("authorization denied: %s", reason))
+			return
+		}
+
+		err := exchange(code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else {
+			fmt.Fprintln(w, "The newsletter can now manage your playlists, you can close this page.")
+		}
+		finish(err)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			finish(err)
+		}
+	}()
+
+	log.Info().Msgf("Open %s to allow the newsletter to manage your %s playlists", authURL, platform)
+	err = <-done
+	server.Shutdown(context.Background())
+	return err
+}
+
+// randomString is 32 random bytes, base64url encoded.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
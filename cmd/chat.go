@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"newmusicrelease/album"
+	"newmusicrelease/subscriber"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// link is where an album can be listened to on one platform.
+type link struct {
+	Platform string
+	URL      string
+}
+
+// albumLinks returns the links of a on the platforms s listens on, skipping the platforms
+// the album wasn't found on.
+func albumLinks(a album.Album, s subscriber.Subscriber) ([]link, error) {
+	urls := []struct {
+		platform string
+		url      func() (string, error)
+	}{
+		{"Spotify", a.GetSpotifyURL},
+		{"Tidal", a.GetTidalURL},
+		{"Deezer", a.GetDeezerURL},
+	}
+
+	var links []link
+	for _, u := range urls {
+		url, err := u.url()
+		if err != nil {
+			return nil, err
+		}
+		if url != "" && s.WantsPlatform(u.platform) {
+			links = append(links, link{Platform: u.platform, URL: url})
+		}
+	}
+	return links, nil
+}
+
+// genreTag turns a genre into a hashtag, e.g. "hip hop" into #hip_hop.
+func genreTag(genre string) string {
+	var tag strings.Builder
+	tag.WriteString("#")
+	separator := false
+	for _, r := range strings.ToLower(genre) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if separator && tag.Len() > 1 {
+				tag.WriteString("_")
+			}
+			tag.WriteRune(r)
+			separator = false
+		} else {
+			separator = true
+		}
+	}
+	return tag.String()
+}
+
+func genreTags(genres []string) string {
+	tags := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		tags = append(tags, genreTag(genre))
+	}
+	return strings.Join(tags, " ")
+}
+
+// chatStyle writes the pieces of a chat message, renderChat puts them together.
+type chatStyle struct {
+	header  func(n Newsletter) string
+	section func(title string) string
+	album   func(a album.Album, links []link) string
+	// more tells how many albums didn't fit in the message.
+	more func(count int) string
+}
+
+// renderMarkdown renders the newsletter as Markdown, for wikis and the chats that
+// understand it. See renderChat for maxLength.
+func renderMarkdown(n Newsletter, maxLength int) (string, error) {
+	return renderChat(n, markdownStyle, maxLength)
+}
+
+// renderCompact renders the newsletter as plain text short enough to be posted in a chat,
+// one line per album and one for its links. See renderChat for maxLength.
+func renderCompact(n Newsletter, maxLength int) (string, error) {
+	return renderChat(n, compactStyle, maxLength)
+}
+
+// renderChat renders the sections of the newsletter with style. When maxLength is above 0,
+// the message holds at most maxLength characters: the albums that don't fit are left out
+// and counted at the end, so the message is never cut in the middle of an album.
+func renderChat(n Newsletter, style chatStyle, maxLength int) (string, error) {
+	var out strings.Builder
+	out.WriteString(style.header(n))
+	length := utf8.RuneCountInString(out.String())
+
+	written := 0
+	total := len(n.Albums)
+	fits := func(s string, left int) bool {
+		if maxLength <= 0 {
+			return true
+		}
+		reserved := 0
+		if left > 0 {
+			reserved = utf8.RuneCountInString(style.more(left))
+		}
+		return length+utf8.RuneCountInString(s)+reserved <= maxLength
+	}
+
+sections:
+	for _, section := range n.Sections {
+		for i, a := range section.Albums {
+			links, err := albumLinks(a, n.Subscriber)
+			if err != nil {
+				return "", err
+			}
+			entry := style.album(a, links)
+			if i == 0 {
+				entry = style.section(section.Title) + entry
+			}
+			if !fits(entry, total-written-1) {
+				break sections
+			}
+			out.WriteString(entry)
+			length += utf8.RuneCountInString(entry)
+			written++
+		}
+	}
+	if written < total {
+		out.WriteString(style.more(total - written))
+	}
+
+	// Only the header can still be too long, with albums left out or not
+	message := out.String()
+	if maxLength > 0 && utf8.RuneCountInString(message) > maxLength {
+		message = string([]rune(message)[:max(maxLength-1, 0)]) + "…"
+	}
+	return message, nil
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+var markdownStyle = chatStyle{
+	header: func(n Newsletter) string {
+		var header strings.Builder
+		fmt.Fprintf(&header, "# New Music Friday – %s\n", n.Date.Format("January 2"))
+		var playlists []string
+		for _, playlist := range n.Playlists {
+			if n.Subscriber.WantsPlatform(playlist.Platform) {
+				playlists = append(playlists, fmt.Sprintf("[%s](%s)", playlist.Platform, playlist.URL))
+			}
+		}
+		if len(playlists) > 0 {
+			fmt.Fprintf(&header, "\nPlaylist: %s\n", strings.Join(playlists, " · "))
+		}
+		if len(n.DegradedPlatforms) > 0 {
+			fmt.Fprintf(&header, "\n_Links may be missing this week for: %s._\n", strings.Join(n.DegradedPlatforms, ", "))
+		}
+		return header.String()
+	},
+	section: func(title string) string {
+		return fmt.Sprintf("\n## %s\n\n", markdownEscaper.Replace(title))
+	},
+	album: func(a album.Album, links []link) string {
+		var entry strings.Builder
+		fmt.Fprintf(&entry, "- **%s** — %s", markdownEscaper.Replace(a.AlbumName), markdownEscaper.Replace(a.ArtistName))
+		for _, l := range links {
+			fmt.Fprintf(&entry, " · [%s](<%s>)", l.Platform, l.URL)
+		}
+		if len(a.Genres) > 0 {
+			fmt.Fprintf(&entry, "  \n  %s", genreTags(a.Genres))
+		}
+		entry.WriteString("\n")
+		return entry.String()
+	},
+	more: func(count int) string {
+		return fmt.Sprintf("\n_…and %d more._\n", count)
+	},
+}
+
+var compactStyle = chatStyle{
+	header: func(n Newsletter) string {
+		var header strings.Builder
+		fmt.Fprintf(&header, "New Music Friday – %s\n", n.Date.Format("January 2"))
+		for _, playlist := range n.Playlists {
+			if n.Subscriber.WantsPlatform(playlist.Platform) {
+				fmt.Fprintf(&header, "Playlist on %s: %s\n", playlist.Platform, playlist.URL)
+			}
+		}
+		return header.String()
+	},
+	section: func(title string) string {
+		return fmt.Sprintf("\n▸ %s\n", title)
+	},
+	album: func(a album.Album, links []link) string {
+		var entry strings.Builder
+		fmt.Fprintf(&entry, "• %s — %s", a.AlbumName, a.ArtistName)
+		if len(a.Genres) > 0 {
+			fmt.Fprintf(&entry, " %s", genreTags(a.Genres))
+		}
+		entry.WriteString("\n")
+		if len(links) > 0 {
+			urls := make([]string, 0, len(links))
+			for _, l := range links {
+				urls = append(urls, l.URL)
+			}
+			fmt.Fprintf(&entry, "  %s\n", strings.Join(urls, " "))
+		}
+		return entry.String()
+	},
+	more: func(count int) string {
+		return fmt.Sprintf("…and %d more\n", count)
+	},
+}
+
+// renderAlbums prints the newsletter of the last run stored in outputDir in format,
+// markdown or compact, for the subscriber with the given email or for everyone when empty.
+func renderAlbums(outputDir string, format string, to string, maxLength int) error {
+	snap, err := readSnapshot(outputDir)
+	if err != nil {
+		return fmt.Errorf("no newsletter rendered in %s, run render html first: %w", outputDir, err)
+	}
+
+	renderers := map[string]func(Newsletter, int) (string, error){
+		"markdown": renderMarkdown,
+		"compact":  renderCompact,
+	}
+	render, ok := renderers[format]
+	if !ok {
+		return fmt.Errorf("unknown render format %q, try markdown or compact", format)
+	}
+
+	var newsletter Newsletter
+	layout := sectionLayoutFromConfig()
+	s, ok := snap.find(to)
+	if ok {
+		newsletter = snap.Newsletter.For(s, snap.Profiles[s.Profile], layout)
+	} else if to != "" {
+		return fmt.Errorf("no subscriber %s in the last run", to)
+	} else {
+		newsletter = snap.Newsletter.For(s, Profile{Genres: allGenres(snap.Profiles)}, layout)
+	}
+
+	message, err := render(newsletter, maxLength)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(os.Stdout, message)
+	return err
+}
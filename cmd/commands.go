@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"newmusicrelease/history"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/telegram"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// flags are the flags of the program. They are shared by every command and can be given
+// before or after it.
+type flags struct {
+	config    string
+	logLevel  string
+	outputDir string
+	dryRun    bool
+	addr      string
+	maxLength int
+}
+
+// command is a subcommand of the program, the first argument that isn't a flag.
+type command struct {
+	name  string
+	args  string
+	usage string
+	// config tells whether the command needs the config file. The ones working on the
+	// output directory of a previous run do without.
+	config bool
+	run    func(o *flags, args []string) error
+}
+
+var commands = []command{
+	{"run", "", "fetch, enrich and send, the default", true, runAll},
+	{"fetch", "", "pick the genres of the profiles and scrape the releases of the week to fetched.json", true, runFetch},
+	{"enrich", "", "find the albums of fetched.json on every platform, rank them and write enriched.json", true, runEnrich},
+	{"render", "[html|markdown|compact] [subscriber]", "render the newsletters of enriched.json to the output directory, or print the last one as Markdown or compact text", false, runRender},
+	{"send", "", "deliver the newsletter of enriched.json to every subscriber", true, runSend},
+	{"auth", "deezer|tidal", "allow the app to manage your Deezer or Tidal playlists", true, runAuth},
+	{"genres", "", "print the genres picked for every profile", true, runGenres},
+	{"history", "[week]", "list the weeks of the history, or the albums of one of them", true, runHistory},
+	{"export", "[json|csv]", "write the albums of the last run to albums.json and albums.csv", false, runExport},
+	{"preview", "", "serve the last run, reloading on template changes", false, runPreview},
+	{"archive", "", "write the web archive and its Atom feed", false, runArchive},
+	{"serve", "", "serve the unsubscribe and preferences pages, the tracked links and the feedback pages", true, runServe},
+	{"telegram", "[webhook]", "record the reactions to the Telegram cards", true, runTelegram},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [arguments]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-44s %s\n", strings.TrimSpace(c.name+" "+c.args), c.usage)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseArgs parses the flags found anywhere among args and returns the other arguments.
+func parseArgs(set *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		err := set.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if set.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, set.Arg(0))
+		args = set.Args()[1:]
+	}
+}
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	o := &flags{}
+	flag.StringVar(&o.config, "config", "configs/config.yaml", "configuration file")
+	flag.StringVar(&o.logLevel, "log-level", "info", "least important messages logged: debug, info, warn or error")
+	flag.StringVar(&o.outputDir, "output-dir", ".", "directory where the stages and the rendered newsletters are written")
+	flag.BoolVar(&o.dryRun, "dry-run", false, "run the whole pipeline but write the newsletters to the output directory instead of sending them")
+	flag.StringVar(&o.addr, "addr", "localhost:8080", "address the servers listen on")
+	flag.IntVar(&o.maxLength, "max-length", 0, "most characters of a rendered message, albums that don't fit are left out; no limit when 0")
+	flag.Usage = usage
+
+	args, err := parseArgs(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	level, err := zerolog.ParseLevel(o.logLevel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fatal error log level")
+	}
+	zerolog.SetGlobalLevel(level)
+
+	name := "run"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	viper.SetConfigFile(o.config)
+	err = viper.ReadInConfig()
+	if err != nil && cmd.config {
+		log.Fatal().Err(err).Msg("fatal error config file")
+	}
+
+	err = cmd.run(o, args)
+	if err != nil {
+		log.Fatal().Err(err).Str("command", name).Msg("error encountered while running the command")
+	}
+}
+
+func loadSubscribers() (*subscriber.Registry, error) {
+	viper.SetDefault("subscribers_file", "configs/subscribers.yaml")
+	registry, err := subscriber.Load(viper.GetString("subscribers_file"))
+	if err != nil {
+		return nil, fmt.Errorf("subscribers file: %w", err)
+	}
+	return registry, nil
+}
+
+// sendOptions are the options of the delivery, with the links to the server when it is
+// configured.
+func (o *flags) sendOptions(dryRun bool) (sendOptions, error) {
+	site, err := siteFromConfig()
+	if err != nil {
+		return sendOptions{}, err
+	}
+	links, err := trackerFromConfig(site)
+	if err != nil {
+		return sendOptions{}, err
+	}
+	return sendOptions{DryRun: dryRun, OutputDir: o.outputDir, Tracker: links, Site: site}, nil
+}
+
+// readStageOf reads the stage left in the output directory, telling which command writes
+// it when there is none.
+func readStageOf(o *flags, name string, command string) (stage, error) {
+	st, err := readStage(o.outputDir, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, fmt.Errorf("no %s in %s, run %s first", name, o.outputDir, command)
+	}
+	return st, err
+}
+
+// checkDeliveries logs how many deliveries succeeded and fails when any didn't. The
+// subscribers skipped this week don't count as failures.
+func checkDeliveries(deliveries []Delivery) error {
+	failed, skipped := 0, 0
+	for _, d := range deliveries {
+		switch {
+		case d.Skipped:
+			skipped++
+		case d.Err != nil:
+			failed++
+		}
+	}
+	log.Info().Int("sent", len(deliveries)-failed-skipped).Int("skipped", skipped).Int("failed", failed).Msg("Delivery summary")
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deliveries failed", failed, len(deliveries)-skipped)
+	}
+	return nil
+}
+
+func runAll(o *flags, args []string) error {
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	ts := themes{}
+	err = checkThemes(ts, registry.Subscribers)
+	if err != nil {
+		return err
+	}
+	opts, err := o.sendOptions(o.dryRun)
+	if err != nil {
+		return err
+	}
+
+	fetched, err := fetch(registry.Subscribers)
+	if err != nil {
+		return err
+	}
+	err = writeStage(o.outputDir, fetchedFile, fetched)
+	if err != nil {
+		return err
+	}
+
+	enriched, err := enrich(fetched)
+	if err != nil {
+		return err
+	}
+	err = writeStage(o.outputDir, enrichedFile, enriched)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := send(enriched, registry.Subscribers, ts, opts)
+	if err != nil {
+		return err
+	}
+	return checkDeliveries(deliveries)
+}
+
+func runFetch(o *flags, args []string) error {
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	fetched, err := fetch(registry.Subscribers)
+	if err != nil {
+		return err
+	}
+	return writeStage(o.outputDir, fetchedFile, fetched)
+}
+
+func runEnrich(o *flags, args []string) error {
+	fetched, err := readStageOf(o, fetchedFile, "fetch")
+	if err != nil {
+		return err
+	}
+	enriched, err := enrich(fetched)
+	if err != nil {
+		return err
+	}
+	return writeStage(o.outputDir, enrichedFile, enriched)
+}
+
+// runRender writes the newsletter of every subscriber to the output directory as a dry
+// run would, or of the one subscriber given. The Markdown and compact formats print the
+// newsletter rendered last instead, see renderAlbums.
+func runRender(o *flags, args []string) error {
+	format := "html"
+	if len(args) > 0 {
+		format = args[0]
+	}
+	to := ""
+	if len(args) > 1 {
+		to = args[1]
+	}
+	if format != "html" {
+		return renderAlbums(o.outputDir, format, to, o.maxLength)
+	}
+
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	subscribers := registry.Subscribers
+	if to != "" {
+		i, ok := registry.Find(to)
+		if !ok {
+			return fmt.Errorf("no subscriber %q", to)
+		}
+		subscribers = subscribers[i : i+1]
+	}
+	ts := themes{}
+	err = checkThemes(ts, subscribers)
+	if err != nil {
+		return err
+	}
+	opts, err := o.sendOptions(true)
+	if err != nil {
+		return err
+	}
+	enriched, err := readStageOf(o, enrichedFile, "enrich")
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := send(enriched, subscribers, ts, opts)
+	if err != nil {
+		return err
+	}
+	return checkDeliveries(deliveries)
+}
+
+func runSend(o *flags, args []string) error {
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	ts := themes{}
+	err = checkThemes(ts, registry.Subscribers)
+	if err != nil {
+		return err
+	}
+	opts, err := o.sendOptions(o.dryRun)
+	if err != nil {
+		return err
+	}
+	enriched, err := readStageOf(o, enrichedFile, "enrich")
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := send(enriched, registry.Subscribers, ts, opts)
+	if err != nil {
+		return err
+	}
+	return checkDeliveries(deliveries)
+}
+
+func runAuth(o *flags, args []string) error {
+	platform := ""
+	if len(args) > 0 {
+		platform = args[0]
+	}
+	return authorize(platform, o.addr)
+}
+
+func runGenres(o *flags, args []string) error {
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(registry.Subscribers)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		label := name
+		if label == "" {
+			label = "main account"
+		}
+		fmt.Printf("%s: %s\n", label, strings.Join(profiles[name].Genres, ", "))
+	}
+	return nil
+}
+
+func runHistory(o *flags, args []string) error {
+	store := history.FromConfig()
+	if len(args) > 0 {
+		w, err := store.Load(args[0])
+		if err != nil {
+			return err
+		}
+		for _, a := range w.Albums {
+			fmt.Printf("%s — %s\t%s\n", a.ArtistName, a.AlbumName, strings.Join(a.Genres, ", "))
+		}
+		return nil
+	}
+
+	weeks, err := store.Weeks()
+	if err != nil {
+		return err
+	}
+	for _, w := range weeks {
+		fmt.Printf("%s\t%d albums\t%d reactions\t%d clicks\n", w.ID(), len(w.Albums), len(w.Reactions), len(w.Clicks))
+	}
+	return nil
+}
+
+func runExport(o *flags, args []string) error {
+	format := ""
+	if len(args) > 0 {
+		format = args[0]
+	}
+	return exportAlbums(o.outputDir, format)
+}
+
+func runPreview(o *flags, args []string) error {
+	// The templates are designed from the repository, read them from disk so changes show up
+	if _, err := os.Stat("newsletter.tmpl"); err == nil {
+		viper.SetDefault("templates_dir", ".")
+	}
+	return previewServer(o.addr, o.outputDir)
+}
+
+func runArchive(o *flags, args []string) error {
+	return buildArchive(o.outputDir)
+}
+
+func runServe(o *flags, args []string) error {
+	site, err := siteFromConfig()
+	if err != nil {
+		return err
+	}
+	links, err := trackerFromConfig(site)
+	if err != nil {
+		return err
+	}
+	viper.SetDefault("subscribers_file", "configs/subscribers.yaml")
+	return serve(site, links, viper.GetString("subscribers_file"), o.addr)
+}
+
+func runTelegram(o *flags, args []string) error {
+	registry, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	rr := &reactionRecorder{bot: telegram.FromConfig(), store: history.FromConfig(), subscribers: registry.Subscribers}
+	if len(args) > 0 && args[0] == "webhook" {
+		return serveReactions(rr, o.addr)
+	}
+	return pollReactions(rr)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// discordMaxEmbeds is how many embeds Discord accepts in a message, the albums are split
+// over as many messages as needed.
+const discordMaxEmbeds = 10
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// discordEmbed is documented at https://discord.com/developers/docs/resources/message#embed-object
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Thumbnail   *discordImage  `json:"thumbnail,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+}
+
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+// discordPayloads builds an embed per album, with its cover and its section in the footer.
+// The first message also holds the title and the playlists.
+func discordPayloads(newsletter Newsletter) ([]any, error) {
+	content := fmt.Sprintf("**New Music Friday – %s**", newsletter.Date.Format("January 2"))
+	var playlists []string
+	for _, playlist := range newsletter.Playlists {
+		if newsletter.Subscriber.WantsPlatform(playlist.Platform) {
+			playlists = append(playlists, fmt.Sprintf("[%s](<%s>)", playlist.Platform, playlist.URL))
+		}
+	}
+	if len(playlists) > 0 {
+		content += "\nPlaylist: " + strings.Join(playlists, " · ")
+	}
+	if len(newsletter.DegradedPlatforms) > 0 {
+		content += "\n*Links may be missing this week for: " + strings.Join(newsletter.DegradedPlatforms, ", ") + ".*"
+	}
+
+	var embeds []discordEmbed
+	for _, section := range newsletter.Sections {
+		for _, a := range section.Albums {
+			links, err := albumLinks(a, newsletter.Subscriber)
+			if err != nil {
+				return nil, err
+			}
+
+			lines := []string{markdownEscaper.Replace(a.ArtistName)}
+			if len(links) > 0 {
+				urls := make([]string, 0, len(links))
+				for _, l := range links {
+					urls = append(urls, fmt.Sprintf("[%s](%s)", l.Platform, l.URL))
+				}
+				lines = append(lines, strings.Join(urls, " · "))
+			}
+			if len(a.Genres) > 0 {
+				lines = append(lines, genreTags(a.Genres))
+			}
+
+			embed := discordEmbed{
+				Title:       a.AlbumName,
+				Description: strings.Join(lines, "\n"),
+				Footer:      &discordFooter{Text: section.Title},
+			}
+			if len(links) > 0 {
+				embed.URL = links[0].URL
+			}
+			if url := coverURL(a); url != "" {
+				embed.Thumbnail = &discordImage{URL: url}
+			}
+			embeds = append(embeds, embed)
+		}
+	}
+
+	messages := []any{}
+	for start := 0; start < len(embeds); start += discordMaxEmbeds {
+		message := discordMessage{Embeds: embeds[start:min(start+discordMaxEmbeds, len(embeds))]}
+		if start == 0 {
+			message.Content = content
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
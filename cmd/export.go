@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"newmusicrelease/export"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exportAlbums writes the albums of the last run stored in outputDir to albums.json and
+// albums.csv in the same directory, or to only one of them when format is json or csv.
+func exportAlbums(outputDir string, format string) error {
+	snap, err := readSnapshot(outputDir)
+	if err != nil {
+		return fmt.Errorf("no run to export in %s, try render first: %w", outputDir, err)
+	}
+
+	doc, err := export.New(snap.Newsletter.Date, snap.Newsletter.Albums)
+	if err != nil {
+		return err
+	}
+
+	writers := map[string]func(io.Writer, export.Document) error{
+		"json": export.WriteJSON,
+		"csv":  export.WriteCSV,
+	}
+	formats := []string{"json", "csv"}
+	if format != "" {
+		if _, ok := writers[format]; !ok {
+			return fmt.Errorf("unknown export format %q, try json or csv", format)
+		}
+		formats = []string{format}
+	}
+
+	for _, format := range formats {
+		path := filepath.Join(outputDir, "albums."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = writers[format](f, doc)
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		log.Info().Str("path", path).Int("albums", len(doc.Albums)).Msg("Albums exported")
+	}
+	return nil
+}
@@ -2,30 +2,36 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"net/smtp"
+	"net/mail"
 	"newmusicrelease/album"
-	"newmusicrelease/deezer"
+	"newmusicrelease/mailer"
+	"newmusicrelease/provider"
 	"newmusicrelease/spotify"
-	"newmusicrelease/tidal"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/theme"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly/v2"
 	"github.com/jordan-wright/email"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/viper"
 )
 
-func getLatestFridayDate() string {
+func getLatestFriday() time.Time {
 	currentDate := time.Now()
 	daysUntilFriday := int((2 + currentDate.Weekday()) % 7)
-	latestFriday := currentDate.AddDate(0, 0, -daysUntilFriday)
-	return latestFriday.Format("20060102")
+	return currentDate.AddDate(0, 0, -daysUntilFriday)
+}
+
+func getLatestFridayDate() string {
+	return getLatestFriday().Format("20060102")
 }
 
 func genreScraper(genre string, albums *[]album.Album) error {
@@ -72,138 +78,275 @@ func genreScraper(genre string, albums *[]album.Album) error {
 	return nil
 }
 
-func emailSender(albums *[]album.Album) error {
-	password := viper.GetString("password")
-	smtpHost := viper.GetString("smtp_host")
-	smtpPort := viper.GetInt("smtp_port")
+// topProfile returns the n genres that come up the most in the top artists and tracks of
+// the account, along with the artists of both.
+func topProfile(account *spotify.Account, timeRange spotify.TimeRange, n int) Profile {
+	var profile Profile
 
-	tmpl, err := template.New("newsletter.tmpl").ParseFiles("newsletter.tmpl")
+	topArtists, err := account.GetTopArtists(timeRange)
 	if err != nil {
-		return err
+		log.Error().Err(err).Str("account", account.Name).Msg("error encountered while getting top artists on Spotify")
 	}
 
-	var body bytes.Buffer
-
-	err = tmpl.Execute(&body, albums)
+	artists := make([]spotify.ArtistObject, len(topArtists))
+	for i := range topArtists {
+		artists[i] = topArtists[i].ArtistObject
+		profile.Artists = append(profile.Artists, topArtists[i].ID)
+	}
+	genresCount := spotify.GenresCount(artists)
 
+	topTracks, err := account.GetTopTracks(timeRange)
 	if err != nil {
-		return err
+		log.Error().Err(err).Str("account", account.Name).Msg("error encountered while getting top tracks on Spotify")
 	}
-
-	f, err := os.Create("./template.html")
+	for _, track := range topTracks {
+		for _, artist := range track.Artists {
+			if !slices.Contains(profile.Artists, artist.ID) {
+				profile.Artists = append(profile.Artists, artist.ID)
+			}
+		}
+	}
+	tracksGenresCount, err := account.TopTracksGenresCount(topTracks)
 	if err != nil {
-		return err
+		log.Error().Err(err).Str("account", account.Name).Msg("error encountered while getting the genres of top tracks on Spotify")
+	}
+	for genre, count := range tracksGenresCount {
+		genresCount[genre] += count
 	}
 
-	tmpl.Execute(f, albums)
-	if err != nil {
-		return err
+	genres := make([]string, 0, len(genresCount))
+	for genre := range genresCount {
+		genres = append(genres, genre)
 	}
+	sort.Slice(genres, func(i, j int) bool {
+		if genresCount[genres[i]] != genresCount[genres[j]] {
+			return genresCount[genres[i]] > genresCount[genres[j]]
+		}
+		return genres[i] < genres[j]
+	})
 
-	f.Close()
+	if len(genres) > n {
+		genres = genres[:n]
+	}
+	profile.Genres = genres
+	return profile
+}
 
-	from := viper.GetString("email")
-	to := "xavierbussiere+testing@gmail.com"
+func logSearchError(err error, platform string, a album.Album) {
+	switch {
+	case errors.Is(err, provider.ErrCircuitOpen):
+		log.Debug().Err(err).Str("album_name", a.AlbumName).Msg("skipped")
+	case errors.Is(err, provider.ErrNotFound), errors.Is(err, provider.ErrUnavailableForLegalReasons):
+		log.Warn().Err(err).Str("platform", platform).Str("album_name", a.AlbumName).Str("artist_name", a.ArtistName).Msg("album not available")
+	default:
+		log.Error().Err(err).Msgf("error encountered while searching the album '%s' on %s", a.AlbumName, platform)
+	}
+}
 
-	e := email.NewEmail()
-	e.From = fmt.Sprintf("Parfait Saucier <%s>", from)
-	e.To = []string{to}
-	e.Subject = "[Test] New Music Friday"
-	e.HTML = body.Bytes()
-	e.AttachFile("logo/spotify/spotify-icon-64.png")
-	e.AttachFile("logo/tidal/tidal-icon-64.png")
-	e.AttachFile("logo/deezer/deezer-icon-64.png")
+type Newsletter struct {
+	Date time.Time
+	// Albums are every album of the run. Once personalized, they are the albums of the
+	// sections, in the order they are rendered.
+	Albums   []album.Album
+	Sections []Section
+	// Playlists are the playlists of the week, one per platform.
+	Playlists []provider.Playlist
+	// DegradedPlatforms lists the platforms that were skipped for part of the run.
+	DegradedPlatforms []string
+	// Subscriber is the recipient the newsletter is rendered for, the zero value links every platform.
+	Subscriber subscriber.Subscriber
+	// Unsubscribe and Preferences are the pages where the subscriber manages their
+	// subscription, empty when there is no server for them.
+	Unsubscribe string
+	Preferences string
+}
 
-	e.Send(fmt.Sprintf("%s:%d", smtpHost, smtpPort), smtp.PlainAuth("", from, password, smtpHost))
+// For returns the newsletter personalized for s, keeping only the albums matching the genres
+// they picked, grouped in sections. The genres they picked are added to the ones of their
+// profile, the albums outside all of them only go in the wildcards.
+func (n Newsletter) For(s subscriber.Subscriber, profile Profile, layout sectionLayout) Newsletter {
+	for _, genre := range s.Genres {
+		if !matchesAny([]string{genre}, profile.Genres) {
+			// Clipped so the profile shared with the other subscribers is left untouched
+			profile.Genres = append(slices.Clip(profile.Genres), genre)
+		}
+	}
 
-	if err != nil {
-		return err
+	var albums []album.Album
+	for _, a := range n.Albums {
+		if s.WantsGenres(a.Genres) {
+			albums = append(albums, a)
+		}
 	}
-	log.Info().Msgf("✨ Email sent successfully to %s ✨", to)
-	return nil
+
+	personalized := n
+	personalized.Subscriber = s
+	personalized.Sections = buildSections(albums, profile, layout)
+	personalized.Albums = nil
+	for _, section := range personalized.Sections {
+		personalized.Albums = append(personalized.Albums, section.Albums...)
+	}
+	return personalized
+}
+
+// matchesAny reports whether one of the genres is wanted, ignoring case like
+// Subscriber.WantsGenres does.
+func matchesAny(genres []string, wanted []string) bool {
+	for _, genre := range genres {
+		for _, w := range wanted {
+			if strings.EqualFold(genre, w) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func main() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+// Delivery is the outcome of sending the newsletter to one subscriber.
+type Delivery struct {
+	Subscriber subscriber.Subscriber
+	Err        error
+	// Skipped is set when the subscriber had nothing to get this week, which isn't an error.
+	Skipped bool
+}
 
-	viper.SetConfigFile("configs/config.yaml")
+// errNoAlbums is returned when no album of the week matches the genres of the subscriber.
+var errNoAlbums = errors.New("no album matches the genres")
+
+// sendOptions controls what emailSender does with the rendered newsletters.
+type sendOptions struct {
+	// DryRun writes the messages to OutputDir instead of sending them.
+	DryRun    bool
+	OutputDir string
+	// Tracker, when set, makes the links of the albums go through the click tracker.
+	Tracker *tracker
+	// Site, when set, is where the unsubscribe and preferences links lead.
+	Site *site
+}
 
-	err := viper.ReadInConfig()
+// emailSender renders the newsletter of the run and delivers it to every subscriber on
+// their channel, see notifierFor.
+func emailSender(newsletter *Newsletter, subscribers []subscriber.Subscriber, profiles map[string]Profile, layout sectionLayout, ts themes, opts sendOptions) ([]Delivery, error) {
+	defaultTheme, err := ts.get(theme.Default)
 	if err != nil {
-		log.Fatal().Err(err).Msg("fatal error config file")
+		return nil, err
 	}
 
-	// Tidal Authorization
-	authKey, err := tidal.GetAuthorization()
+	err = os.MkdirAll(opts.OutputDir, 0o755)
 	if err != nil {
-		log.Error().Err(err).Msg("")
+		return nil, err
 	}
 
-	// Spotify Authorization
-	err = spotify.GetAccessToken()
+	f, err := os.Create(filepath.Join(opts.OutputDir, "template.html"))
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error while making a request for an access token with Spotify")
+		return nil, err
 	}
 
-	var albums []album.Album
-
-	// TODO: Get a user's listening data to know which genre to fetch
-	genresCount, err := spotify.GetTopArtists()
+	// Every album of the run, grouped along the genres of all the profiles
+	err = defaultTheme.HTML.Execute(f, newsletter.For(subscriber.Subscriber{}, Profile{Genres: allGenres(profiles)}, layout))
+	f.Close()
 	if err != nil {
-		log.Error().Err(err).Msg("error encountered while getting top albums on Spotify")
+		return nil, err
 	}
 
-	genres := make([]string, len(genresCount))
-
-	i := 0
-	for genre := range genresCount {
-		genres[i] = genre
-		i++
+	err = writeSnapshot(opts.OutputDir, snapshot{Newsletter: *newsletter, Subscribers: subscribers, Profiles: profiles})
+	if err != nil {
+		return nil, err
 	}
 
-	genres = []string{"hip hop", "rap"}
-	//genres := []string{"german indie", "phonk", "wonky", "rap", "indietronica", "rock", "new wave", "electro", "art pop", "hip hop", "indie soul"}
-
-	for _, genre := range genres {
-		err = genreScraper(genre, &albums)
-		if err != nil {
-			log.Error().Err(err).Msg("")
+	// Only connect to the mail server when someone gets the newsletter by email
+	var transport *mailer.Transport
+	for _, s := range subscribers {
+		if s.GetChannel() == subscriber.ChannelEmail && !opts.DryRun && transport == nil {
+			transport, err = mailer.New(mailer.FromConfig())
+			if err != nil {
+				return nil, err
+			}
+			defer transport.Close()
 		}
 	}
 
-	albums = album.RemoveCopies(albums)
-
-	for i := range albums {
-		log.Debug().Str("album_name", albums[i].AlbumName).Str("artist_name", albums[i].ArtistName).Msg("")
-	}
+	deliveries := make([]Delivery, 0, len(subscribers))
+	for _, s := range subscribers {
+		if !s.Due(newsletter.Date) {
+			log.Info().Str("to", s.ID()).Str("frequency", s.Frequency).Msg("Not this week")
+			deliveries = append(deliveries, Delivery{Subscriber: s, Skipped: true})
+			continue
+		}
 
-	for i := range albums {
-		err = tidal.SearchAlbum(&albums[i], authKey)
-		if err != nil {
-			log.Error().Err(err).Msgf("error encountered while searching the album '%s' on Tidal", albums[i].AlbumName)
+		personalized := newsletter.For(s, profiles[s.Profile], layout)
+		if opts.Site != nil {
+			personalized.Unsubscribe = opts.Site.unsubscribeURL(s.ID())
+			personalized.Preferences = opts.Site.preferencesURL(s.ID())
 		}
-		err = spotify.SearchAlbum(&albums[i])
-		if err != nil {
-			log.Error().Err(err).Msgf("error encountered while searching the album '%s' on Spotify", albums[i].AlbumName)
+		notifier, err := notifierFor(s, ts, transport, opts)
+		if err == nil && opts.Tracker != nil {
+			personalized, err = opts.Tracker.track(personalized)
 		}
-		err = deezer.SearchAlbum(&albums[i])
-		if err != nil {
-			log.Error().Err(err).Msgf("error encountered while searching the album '%s' on Deezer", albums[i].AlbumName)
+		if err == nil {
+			err = notifier.Notify(personalized)
+		}
+		if errors.Is(err, errNoAlbums) {
+			log.Info().Str("to", s.ID()).Strs("genres", s.Genres).Msg("No album this week")
+			deliveries = append(deliveries, Delivery{Subscriber: s, Skipped: true})
+			continue
 		}
-		err = spotify.GetArtists(&albums[i])
 		if err != nil {
-			log.Error().Err(err).Msgf("error encountered while getting the artist '%s' info on Spotify", albums[i].ArtistName)
+			log.Error().Err(err).Str("to", s.ID()).Str("channel", s.GetChannel()).Msg("error encountered while sending the newsletter")
+		} else if opts.DryRun {
+			log.Info().Str("output_dir", opts.OutputDir).Msgf("Dry run, %s to %s written to disk", s.GetChannel(), s.ID())
+		} else if s.GetChannel() == subscriber.ChannelEmail {
+			log.Info().Msgf("✨ Email sent successfully to %s ✨", s.Email)
+		} else {
+			log.Info().Msgf("✨ Newsletter posted successfully to %s on %s ✨", s.ID(), s.GetChannel())
 		}
+		deliveries = append(deliveries, Delivery{Subscriber: s, Err: err})
+	}
+	return deliveries, nil
+}
+
+func buildEmail(t *theme.Theme, newsletter Newsletter, from string) (*email.Email, error) {
+	if len(newsletter.Albums) == 0 {
+		return nil, fmt.Errorf("%w %v", errNoAlbums, newsletter.Subscriber.Genres)
+	}
+
+	loc, err := newsletter.Subscriber.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	err = t.HTML.Execute(&body, newsletter)
+	if err != nil {
+		return nil, err
 	}
-	err = spotify.GetAlbums(&albums)
+
+	html, err := theme.InlineCSS(body.Bytes())
 	if err != nil {
-		log.Error().Err(err).Msgf("error encountered during spotify.GetAlbums")
+		return nil, err
 	}
 
-	albums = album.RankByPopularity(albums)
+	text, err := renderText(t.Text, newsletter)
+	if err != nil {
+		return nil, err
+	}
 
-	err = emailSender(&albums)
+	e := email.NewEmail()
+	e.From = (&mail.Address{Name: "Parfait Saucier", Address: from}).String()
+	e.To = []string{newsletter.Subscriber.Address()}
+	e.Subject = fmt.Sprintf("New Music Friday – %s", newsletter.Date.Format("January 2"))
+	e.Headers.Set("Date", time.Now().In(loc).Format(time.RFC1123Z))
+	if newsletter.Unsubscribe != "" {
+		// One-click unsubscribe, RFC 8058
+		e.Headers.Set("List-Unsubscribe", "<"+newsletter.Unsubscribe+">")
+		e.Headers.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	e.HTML = html
+	e.Text = text
+	err = attachInlineImages(e, inlineImages(t))
 	if err != nil {
-		log.Error().Err(err).Msg("error encountered during sending email")
+		return nil, err
 	}
+
+	return e, nil
 }
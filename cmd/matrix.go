@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"newmusicrelease/album"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// matrixMaxLength keeps the message well under the 64 KiB Matrix allows for an event, the
+// HTML and the text being sent together.
+const matrixMaxLength = 24000
+
+// matrixMessage is an m.room.message event, see
+// https://spec.matrix.org/latest/client-server-api/#mroommessage
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// matrixNotifier posts the newsletter in room as the user of matrix.access_token, on the
+// homeserver at matrix.homeserver.
+func matrixNotifier(room string, dryRunFile string) (Notifier, error) {
+	viper.SetDefault("matrix.homeserver", "https://matrix.org")
+
+	// The transaction ID makes the homeserver ignore the same message sent again on a retry
+	txnID := make([]byte, 16)
+	_, err := rand.Read(txnID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(viper.GetString("matrix.homeserver"), "/"), url.PathEscape(room), hex.EncodeToString(txnID))
+
+	return &webhookNotifier{
+		Name:       "Matrix",
+		URL:        endpoint,
+		Method:     http.MethodPut,
+		Header:     http.Header{"Authorization": {"Bearer " + viper.GetString("matrix.access_token")}},
+		Payloads:   matrixPayloads,
+		DryRunFile: dryRunFile,
+	}, nil
+}
+
+func matrixPayloads(newsletter Newsletter) ([]any, error) {
+	text, err := renderCompact(newsletter, matrixMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	formatted, err := renderChat(newsletter, matrixStyle, matrixMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	return []any{matrixMessage{MsgType: "m.text", Body: text, Format: "org.matrix.custom.html", FormattedBody: formatted}}, nil
+}
+
+// matrixStyle renders the subset of HTML Matrix clients display.
+var matrixStyle = chatStyle{
+	header: func(n Newsletter) string {
+		var header strings.Builder
+		fmt.Fprintf(&header, "<h3>New Music Friday – %s</h3>", n.Date.Format("January 2"))
+		var playlists []string
+		for _, playlist := range n.Playlists {
+			if n.Subscriber.WantsPlatform(playlist.Platform) {
+				playlists = append(playlists, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(playlist.URL), playlist.Platform))
+			}
+		}
+		if len(playlists) > 0 {
+			fmt.Fprintf(&header, "<p>Playlist: %s</p>", strings.Join(playlists, " · "))
+		}
+		if len(n.DegradedPlatforms) > 0 {
+			fmt.Fprintf(&header, "<p><em>Links may be missing this week for: %s.</em></p>", html.EscapeString(strings.Join(n.DegradedPlatforms, ", ")))
+		}
+		return header.String()
+	},
+	section: func(title string) string {
+		return fmt.Sprintf("<h4>%s</h4>", html.EscapeString(title))
+	},
+	album: func(a album.Album, links []link) string {
+		var entry strings.Builder
+		fmt.Fprintf(&entry, "<p><strong>%s</strong> — %s", html.EscapeString(a.AlbumName), html.EscapeString(a.ArtistName))
+		for _, l := range links {
+			fmt.Fprintf(&entry, ` · <a href="%s">%s</a>`, html.EscapeString(l.URL), l.Platform)
+		}
+		if len(a.Genres) > 0 {
+			fmt.Fprintf(&entry, "<br>%s", html.EscapeString(genreTags(a.Genres)))
+		}
+		entry.WriteString("</p>")
+		return entry.String()
+	},
+	more: func(count int) string {
+		return fmt.Sprintf("<p><em>…and %d more</em></p>", count)
+	},
+}
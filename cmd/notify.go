@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"newmusicrelease/export"
+	"newmusicrelease/mailer"
+	"newmusicrelease/provider"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/telegram"
+	"newmusicrelease/theme"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Notifier delivers the newsletter personalized for one subscriber.
+type Notifier interface {
+	Notify(newsletter Newsletter) error
+}
+
+// notifierFor returns the notifier of the channel picked by s. transport is only used
+// by the email channel, and may be nil for a dry run.
+func notifierFor(s subscriber.Subscriber, ts themes, transport *mailer.Transport, opts sendOptions) (Notifier, error) {
+	var dryRunFile string
+	if opts.DryRun {
+		dryRunFile = filepath.Join(opts.OutputDir, unsafeFileChars.ReplaceAllString(s.ID(), "_")+"."+s.GetChannel()+".json")
+	}
+
+	switch s.GetChannel() {
+	case subscriber.ChannelEmail:
+		t, err := ts.get(s.Theme)
+		if err != nil {
+			return nil, err
+		}
+		return &emailNotifier{theme: t, from: viper.GetString("email"), transport: transport, opts: opts}, nil
+	case subscriber.ChannelWebhook:
+		secret := viper.GetString("webhook.secret")
+		return &webhookNotifier{Name: "webhook", URL: s.Webhook, Secret: secret, Payloads: webhookPayloads, DryRunFile: dryRunFile}, nil
+	case subscriber.ChannelSlack:
+		return &webhookNotifier{Name: "Slack", URL: s.Webhook, Payloads: slackPayloads, DryRunFile: dryRunFile}, nil
+	case subscriber.ChannelDiscord:
+		return &webhookNotifier{Name: "Discord", URL: s.Webhook, Payloads: discordPayloads, DryRunFile: dryRunFile}, nil
+	case subscriber.ChannelMatrix:
+		return matrixNotifier(s.Room, dryRunFile)
+	case subscriber.ChannelTelegram:
+		return &telegramNotifier{bot: telegram.FromConfig(), chatID: s.ChatID, DryRunFile: dryRunFile}, nil
+	}
+	return nil, fmt.Errorf("unknown channel %q", s.Channel)
+}
+
+// emailNotifier sends the newsletter by email, or writes it to the output directory for
+// a dry run.
+type emailNotifier struct {
+	theme     *theme.Theme
+	from      string
+	transport *mailer.Transport
+	opts      sendOptions
+}
+
+func (n *emailNotifier) Notify(newsletter Newsletter) error {
+	e, err := buildEmail(n.theme, newsletter, n.from)
+	if err != nil {
+		return err
+	}
+	if n.opts.DryRun {
+		return writeEmail(n.opts.OutputDir, newsletter.Subscriber, e)
+	}
+	return n.transport.Send(e)
+}
+
+// webhookNotifier posts the JSON payloads built from the newsletter to URL, one request
+// per payload, in order.
+type webhookNotifier struct {
+	// Name is the service behind the URL, for the errors.
+	Name string
+	URL  string
+	// Method is POST when empty.
+	Method string
+	Header http.Header
+	// Secret, when set, signs the body of every request with HMAC-SHA256 in the
+	// X-Signature-256 header, formatted as sha256=<hex>.
+	Secret   string
+	Payloads func(newsletter Newsletter) ([]any, error)
+	// DryRunFile, when set, is where the payloads are written instead of being posted.
+	DryRunFile string
+}
+
+func (n *webhookNotifier) Notify(newsletter Newsletter) error {
+	if len(newsletter.Albums) == 0 {
+		return fmt.Errorf("%w %v", errNoAlbums, newsletter.Subscriber.Genres)
+	}
+
+	payloads, err := n.Payloads(newsletter)
+	if err != nil {
+		return err
+	}
+
+	if n.DryRunFile != "" {
+		data, err := json.MarshalIndent(payloads, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(n.DryRunFile, data, 0o644)
+	}
+
+	for _, payload := range payloads {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		err = provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, func() error {
+			return n.post(body)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *webhookNotifier) post(payload []byte) error {
+	method := n.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for key, values := range n.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Slack answers 200, Discord 204, any success will do
+	expected := http.StatusOK
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		expected = resp.StatusCode
+	}
+	err = provider.CheckStatus(n.Name, expected, resp.StatusCode, body)
+	if err != nil && !errors.Is(err, provider.ErrRateLimited) {
+		log.Error().Int("status_code", resp.StatusCode).Str("notifier", n.Name).Bytes("body", body).Msg("")
+	}
+	return err
+}
+
+// webhookPayload is what the generic webhook receives: the albums in the schema of the
+// JSON export, and the newsletter rendered as Markdown.
+type webhookPayload struct {
+	export.Document
+	Subscriber string `json:"subscriber"`
+	Markdown   string `json:"markdown"`
+}
+
+func webhookPayloads(newsletter Newsletter) ([]any, error) {
+	doc, err := export.New(newsletter.Date, newsletter.Albums)
+	if err != nil {
+		return nil, err
+	}
+	markdown, err := renderMarkdown(newsletter, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []any{webhookPayload{Document: doc, Subscriber: newsletter.Subscriber.Name, Markdown: markdown}}, nil
+}
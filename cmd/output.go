@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"newmusicrelease/album"
+	"newmusicrelease/subscriber"
+
+	"github.com/jordan-wright/email"
+)
+
+// snapshot is what a run leaves in the output directory so the newsletter can be
+// rendered again by the preview server without calling every platform.
+type snapshot struct {
+	Newsletter  Newsletter
+	Subscribers []subscriber.Subscriber
+	Profiles    map[string]Profile
+}
+
+const snapshotFile = "newsletter.json"
+
+func writeSnapshot(dir string, snap snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, snapshotFile), data, 0o644)
+}
+
+func readSnapshot(dir string) (snapshot, error) {
+	var snap snapshot
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// stage is what fetch and enrich leave in the output directory for the next stage.
+type stage struct {
+	Date time.Time
+	// Profiles are the profiles of the subscribers, see loadProfiles.
+	Profiles          map[string]Profile
+	Albums            []album.Album
+	DegradedPlatforms []string
+}
+
+// The files of the stages in the output directory.
+const (
+	fetchedFile  = "fetched.json"
+	enrichedFile = "enriched.json"
+)
+
+func writeStage(dir string, name string, st stage) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0o644)
+}
+
+func readStage(dir string, name string) (stage, error) {
+	var st stage
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// writeEmail writes the HTML and text bodies of e, and the whole MIME message as it
+// would have been sent, next to each other in dir.
+func writeEmail(dir string, s subscriber.Subscriber, e *email.Email) error {
+	base := filepath.Join(dir, unsafeFileChars.ReplaceAllString(s.Email, "_"))
+
+	err := os.WriteFile(base+".html", e.HTML, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if len(e.Text) > 0 {
+		err = os.WriteFile(base+".txt", e.Text, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	msg, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".eml", msg, 0o644)
+}
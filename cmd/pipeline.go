@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"newmusicrelease/album"
+	"newmusicrelease/cover"
+	"newmusicrelease/deezer"
+	"newmusicrelease/history"
+	"newmusicrelease/provider"
+	"newmusicrelease/spotify"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/tidal"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// The run goes through three stages, each of them leaving its result in the output
+// directory for the next one so they can also be run on their own:
+//
+//   - fetch picks the genres of the profiles and scrapes the releases of the week
+//   - enrich finds the albums on every platform, ranks them and prepares their covers
+//   - send renders the newsletter of every subscriber and delivers it
+
+// newBreakers returns a circuit breaker per platform, see provider.Breaker.
+func newBreakers() []*provider.Breaker {
+	viper.SetDefault("breaker.threshold", 5)
+	viper.SetDefault("breaker.cooldown", "0s")
+	threshold := viper.GetInt("breaker.threshold")
+	cooldown := viper.GetDuration("breaker.cooldown")
+	return []*provider.Breaker{
+		provider.NewBreaker(provider.Tidal, threshold, cooldown),
+		provider.NewBreaker(provider.Spotify, threshold, cooldown),
+		provider.NewBreaker(provider.Deezer, threshold, cooldown),
+	}
+}
+
+// loadProfiles returns the profile of every Spotify account of the subscribers, keyed by
+// their profile file. The subscribers without one share the main account, under "", and
+// so do the ones whose profile can't be used.
+func loadProfiles(subscribers []subscriber.Subscriber) (map[string]Profile, error) {
+	err := spotify.GetAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("error while making a request for an access token with Spotify: %w", err)
+	}
+
+	viper.SetDefault("spotify.time_range", string(spotify.MediumTerm))
+	viper.SetDefault("genres_per_profile", 10)
+	timeRange := spotify.TimeRange(viper.GetString("spotify.time_range"))
+
+	accounts := make(map[string]*spotify.Account)
+	// The subscribers whose profile can't be used get the albums of the main account
+	failed := make(map[string]bool)
+	for _, s := range subscribers {
+		if _, ok := accounts[s.Profile]; ok || failed[s.Profile] {
+			continue
+		}
+		if s.Profile == "" {
+			accounts[s.Profile] = spotify.Default()
+			continue
+		}
+		account, err := spotify.LoadAccount(s.Name, s.Profile)
+		if err != nil {
+			log.Error().Err(err).Str("profile", s.Profile).Msg("error encountered while loading the profile, the main account is used instead")
+			failed[s.Profile] = true
+			continue
+		}
+		err = account.GetAccessToken()
+		if err != nil {
+			log.Error().Err(err).Str("profile", s.Profile).Msg("Error while making a request for an access token with Spotify, the main account is used instead")
+			failed[s.Profile] = true
+			continue
+		}
+		accounts[s.Profile] = account
+	}
+	if _, ok := accounts[""]; !ok && len(failed) > 0 {
+		accounts[""] = spotify.Default()
+	}
+
+	profiles := make(map[string]Profile)
+	for name, account := range accounts {
+		profile := topProfile(account, timeRange, viper.GetInt("genres_per_profile"))
+		// An empty list, as in the sample config, keeps the genres of the listening history
+		if genres := viper.GetStringSlice("genres"); name == "" && len(genres) > 0 {
+			profile.Genres = genres
+		}
+		log.Info().Str("account", account.Name).Strs("genres", profile.Genres).Int("artists", len(profile.Artists)).Msg("Profile selected")
+		profiles[name] = profile
+	}
+	for name := range failed {
+		profiles[name] = profiles[""]
+	}
+	return profiles, nil
+}
+
+// fetch scrapes the releases of the week for the genres of every profile, once for all
+// of them.
+func fetch(subscribers []subscriber.Subscriber) (stage, error) {
+	profiles, err := loadProfiles(subscribers)
+	if err != nil {
+		return stage{}, err
+	}
+
+	var albums []album.Album
+	for _, genre := range allGenres(profiles) {
+		err = genreScraper(genre, &albums)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}
+
+	albums = album.RemoveCopies(albums)
+
+	for i := range albums {
+		log.Debug().Str("album_name", albums[i].AlbumName).Str("artist_name", albums[i].ArtistName).Msg("")
+	}
+	log.Info().Int("albums", len(albums)).Msg("Releases fetched")
+
+	return stage{Date: getLatestFriday(), Profiles: profiles, Albums: albums}, nil
+}
+
+// enrich looks the fetched albums up on every platform, ranks them by popularity and
+// feedback, and prepares their covers.
+func enrich(fetched stage) (stage, error) {
+	breakers := newBreakers()
+	tidalBreaker, spotifyBreaker, deezerBreaker := breakers[0], breakers[1], breakers[2]
+
+	// Tidal Authorization
+	authKey, err := tidal.GetAuthorization()
+	if err != nil {
+		log.Error().Err(err).Msg("Tidal will be skipped for this run")
+		tidalBreaker.Trip(err)
+	}
+
+	// Spotify Authorization
+	err = spotify.GetAccessToken()
+	if err != nil {
+		return stage{}, fmt.Errorf("error while making a request for an access token with Spotify: %w", err)
+	}
+
+	albums := fetched.Albums
+	for i := range albums {
+		a := &albums[i]
+		err = tidalBreaker.Do(func() error { return tidal.SearchAlbum(a, authKey) })
+		if err != nil {
+			logSearchError(err, provider.Tidal, albums[i])
+		}
+		err = spotifyBreaker.Do(func() error { return spotify.SearchAlbum(a) })
+		if err != nil {
+			logSearchError(err, provider.Spotify, albums[i])
+		}
+		err = deezerBreaker.Do(func() error { return deezer.SearchAlbum(a) })
+		if err != nil {
+			logSearchError(err, provider.Deezer, albums[i])
+		}
+		err = spotifyBreaker.Do(func() error { return spotify.GetArtists(a) })
+		if err != nil && !errors.Is(err, provider.ErrCircuitOpen) {
+			log.Error().Err(err).Msgf("error encountered while getting the artist '%s' info on Spotify", albums[i].ArtistName)
+		}
+	}
+	err = spotifyBreaker.Do(func() error { return spotify.GetAlbums(&albums) })
+	if err != nil {
+		log.Error().Err(err).Msgf("error encountered during spotify.GetAlbums")
+	}
+
+	albums = album.RankByPopularity(albums)
+
+	// What the subscribers clicked and answered in the past weeks moves the albums up or down
+	viper.SetDefault("feedback.strength", 10)
+	viper.SetDefault("feedback.half_life", 8)
+	weeks, err := history.FromConfig().Weeks()
+	if err != nil {
+		log.Error().Err(err).Msg("error encountered while reading the history, the albums are ranked by popularity only")
+	} else {
+		weights := history.Learn(weeks, time.Now(), viper.GetFloat64("feedback.half_life"))
+		albums = weights.Apply(albums, viper.GetFloat64("feedback.strength"))
+	}
+
+	covers := cover.FromConfig()
+	for i := range albums {
+		albums[i].Cover, err = covers.Fetch(albums[i])
+		if err != nil {
+			// The cover may not be in the cache, the newsletter links to the album art instead
+			log.Error().Err(err).Str("album_name", albums[i].AlbumName).Msg("error encountered while preparing the cover")
+			albums[i].Cover = ""
+		}
+	}
+
+	enriched := fetched
+	enriched.Albums = albums
+	enriched.DegradedPlatforms = degradedPlatforms(fetched.DegradedPlatforms, breakers)
+	log.Info().Int("albums", len(albums)).Strs("degraded_platforms", enriched.DegradedPlatforms).Msg("Run summary")
+	return enriched, nil
+}
+
+// degradedPlatforms adds the platforms of the breakers that were tripped to the ones
+// already degraded.
+func degradedPlatforms(degraded []string, breakers []*provider.Breaker) []string {
+	for _, b := range breakers {
+		if b.Degraded() && !slices.Contains(degraded, b.Platform) {
+			degraded = append(degraded, b.Platform)
+		}
+	}
+	return degraded
+}
+
+// send syncs the playlists of the week, saves the week in the history and delivers the
+// newsletter to every subscriber with the themes checked by checkThemes. Only the delivery
+// happens in a dry run, to the output directory.
+func send(enriched stage, subscribers []subscriber.Subscriber, ts themes, opts sendOptions) ([]Delivery, error) {
+	var err error
+	newsletter := Newsletter{Date: enriched.Date, Albums: enriched.Albums, DegradedPlatforms: enriched.DegradedPlatforms}
+	layout := sectionLayoutFromConfig()
+
+	// The albums of the newsletter once grouped and limited, as for a subscriber of every genre
+	selected := newsletter.For(subscriber.Subscriber{}, Profile{Genres: allGenres(enriched.Profiles)}, layout).Albums
+
+	viper.SetDefault("playlist.enabled", false)
+	if viper.GetBool("playlist.enabled") && opts.DryRun {
+		log.Info().Msg("Dry run, the playlist of the week is left untouched")
+	} else if viper.GetBool("playlist.enabled") {
+		err = spotify.GetAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("error while making a request for an access token with Spotify: %w", err)
+		}
+		breakers := newBreakers()
+		newsletter.Playlists = syncPlaylists(selected, newsletter.Date, breakers)
+		newsletter.DegradedPlatforms = degradedPlatforms(newsletter.DegradedPlatforms, breakers)
+	}
+
+	if opts.DryRun {
+		log.Info().Msg("Dry run, the week is not saved in the history")
+	} else {
+		week := history.Week{Date: newsletter.Date, Albums: sentAlbums(newsletter, selected, subscribers, enriched.Profiles, layout), Playlists: newsletter.Playlists}
+		err = history.FromConfig().Save(week)
+		if err != nil {
+			log.Error().Err(err).Msg("error encountered while saving the week in the history")
+		}
+	}
+
+	return emailSender(&newsletter, subscribers, enriched.Profiles, layout, ts, opts)
+}
+
+// sentAlbums are the selected albums followed by the other ones some subscriber gets, such
+// as the familiar ones of their profile, so the reactions to every album sent are learned
+// from.
+func sentAlbums(newsletter Newsletter, selected []album.Album, subscribers []subscriber.Subscriber, profiles map[string]Profile, layout sectionLayout) []album.Album {
+	id := func(a album.Album) string {
+		return a.Key() + "\x00" + a.ArtistName + "\x00" + a.AlbumName
+	}
+
+	albums := slices.Clone(selected)
+	seen := make(map[string]bool)
+	for _, a := range albums {
+		seen[id(a)] = true
+	}
+	for _, s := range subscribers {
+		for _, a := range newsletter.For(s, profiles[s.Profile], layout).Albums {
+			if !seen[id(a)] {
+				seen[id(a)] = true
+				albums = append(albums, a)
+			}
+		}
+	}
+	return albums
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"newmusicrelease/album"
+	"newmusicrelease/deezer"
+	"newmusicrelease/provider"
+	"newmusicrelease/spotify"
+	"newmusicrelease/tidal"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// playlistName is the name of the playlist of the week.
+func playlistName(date time.Time) string {
+	return fmt.Sprintf("New Music Friday – %s", date.Format("January 2, 2006"))
+}
+
+// playlistTracks picks n tracks of every album: the most popular ones when pick is "top",
+// the first ones of the track list when it is "first". The tracks follow the order of the
+// albums.
+func playlistTracks(account *spotify.Account, albums []album.Album, n int, pick string) ([]spotify.Track, error) {
+	var ids []string
+	for _, a := range albums {
+		for _, track := range a.Spotify.Tracks {
+			ids = append(ids, track.Id)
+		}
+	}
+
+	// The tracks of an album have no popularity, the full track objects do
+	fullTracks, err := account.GetTracks(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]spotify.Track, len(fullTracks))
+	for _, track := range fullTracks {
+		byID[track.ID] = track
+	}
+
+	var tracks []spotify.Track
+	for _, a := range albums {
+		var albumTracks []spotify.Track
+		for _, track := range a.Spotify.Tracks {
+			if full, ok := byID[track.Id]; ok {
+				albumTracks = append(albumTracks, full)
+			}
+		}
+		if pick != "first" {
+			sort.SliceStable(albumTracks, func(i, j int) bool {
+				return albumTracks[i].Popularity > albumTracks[j].Popularity
+			})
+		}
+		tracks = append(tracks, albumTracks[:min(n, len(albumTracks))]...)
+	}
+	return tracks, nil
+}
+
+// syncPlaylists creates or updates the playlist of the week with tracks of the selected
+// albums on every platform of playlist.platforms. Spotify picks the tracks, Deezer and Tidal
+// mirror them by ISRC. A platform that fails is left out of the returned playlists.
+func syncPlaylists(albums []album.Album, date time.Time, breakers []*provider.Breaker) []provider.Playlist {
+	viper.SetDefault("playlist.tracks_per_album", 1)
+	viper.SetDefault("playlist.pick", "top")
+	viper.SetDefault("playlist.public", false)
+	viper.SetDefault("playlist.platforms", []string{provider.Spotify})
+
+	account := spotify.Default()
+	name := playlistName(date)
+	description := "New releases of the week picked by the New Music Friday newsletter."
+	public := viper.GetBool("playlist.public")
+	platforms := viper.GetStringSlice("playlist.platforms")
+
+	var tracks []spotify.Track
+	err := breakerFor(breakers, provider.Spotify).Do(func() error {
+		var err error
+		tracks, err = playlistTracks(account, albums, viper.GetInt("playlist.tracks_per_album"), viper.GetString("playlist.pick"))
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error encountered while picking the tracks of the playlist")
+		return nil
+	}
+
+	var playlists []provider.Playlist
+	for _, platform := range platforms {
+		var playlist provider.Playlist
+		err := breakerFor(breakers, platform).Do(func() error {
+			var err error
+			switch platform {
+			case provider.Spotify:
+				uris := make([]string, len(tracks))
+				for i, track := range tracks {
+					uris[i] = track.URI
+				}
+				playlist, err = account.SyncPlaylist(name, description, public, uris)
+			case provider.Deezer:
+				playlist, err = deezer.SyncPlaylist(name, description, public, mirrorTracks(tracks, platform, deezerTrack))
+			case provider.Tidal:
+				playlist, err = tidal.SyncPlaylist(name, description, public, mirrorTracks(tracks, platform, tidal.TrackByISRC))
+			default:
+				err = fmt.Errorf("no playlist support for %s", platform)
+			}
+			return err
+		})
+		if err != nil {
+			log.Error().Err(err).Str("platform", platform).Msg("error encountered while syncing the playlist of the week")
+			continue
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists
+}
+
+// mirrorTracks looks up the tracks on another platform by ISRC. The tracks it doesn't have
+// are skipped.
+func mirrorTracks[T any](tracks []spotify.Track, platform string, byISRC func(string) (T, error)) []T {
+	var ids []T
+	for _, track := range tracks {
+		if track.ExternalIDs.ISRC == "" {
+			continue
+		}
+		id, err := byISRC(track.ExternalIDs.ISRC)
+		if errors.Is(err, provider.ErrNotFound) {
+			log.Warn().Str("platform", platform).Str("isrc", track.ExternalIDs.ISRC).Str("track_name", track.Name).Msg("track not available, left out of the playlist")
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("platform", platform).Str("isrc", track.ExternalIDs.ISRC).Msg("error encountered while looking up the track")
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func deezerTrack(isrc string) (int, error) {
+	track, err := deezer.TrackByISRC(isrc)
+	return track.ID, err
+}
+
+// breakerFor returns the breaker of platform, or one that never trips for an unknown platform.
+func breakerFor(breakers []*provider.Breaker, platform string) *provider.Breaker {
+	for _, b := range breakers {
+		if b.Platform == platform {
+			return b
+		}
+	}
+	// A breaker trips once its failures reach the threshold, which they never do here
+	return provider.NewBreaker(platform, math.MaxInt, 0)
+}
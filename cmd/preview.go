@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/theme"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// liveReload polls the preview server and reloads the page once the template changed on disk.
+const liveReload = `<script>
+(function() {
+	var version = null;
+	setInterval(function() {
+		fetch("/_version" + location.search).then(function(r) { return r.text(); }).then(function(v) {
+			if (version !== null && v !== version) { location.reload(); }
+			version = v;
+		});
+	}, 1000);
+})();
+</script>`
+
+// subscriber returns the subscriber picked with ?to=<email>.
+func (snap snapshot) subscriber(r *http.Request) (subscriber.Subscriber, bool) {
+	return snap.find(r.URL.Query().Get("to"))
+}
+
+// find returns the subscriber with the given ID, their email or their name when they
+// have none.
+func (snap snapshot) find(id string) (subscriber.Subscriber, bool) {
+	for _, s := range snap.Subscribers {
+		if s.ID() == id {
+			return s, true
+		}
+	}
+	return subscriber.Subscriber{}, false
+}
+
+// themeName returns the theme picked with ?theme=<name>, or the one of the subscriber.
+func (snap snapshot) themeName(r *http.Request) string {
+	if name := r.URL.Query().Get("theme"); name != "" {
+		return name
+	}
+	s, _ := snap.subscriber(r)
+	return s.Theme
+}
+
+// previewServer serves the newsletter of the last run stored in outputDir. The template
+// is parsed again on every request so it can be designed without running the pipeline.
+func previewServer(addr string, outputDir string) error {
+	snap, err := readSnapshot(outputDir)
+	if err != nil {
+		return fmt.Errorf("no run to preview in %s, try render first: %w", outputDir, err)
+	}
+
+	layout := sectionLayoutFromConfig()
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var newsletter Newsletter
+		s, ok := snap.subscriber(r)
+		if ok {
+			newsletter = snap.Newsletter.For(s, snap.Profiles[s.Profile], layout)
+		} else {
+			newsletter = snap.Newsletter.For(s, Profile{Genres: allGenres(snap.Profiles)}, layout)
+		}
+
+		t, err := theme.Load(snap.themeName(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var body bytes.Buffer
+		err = t.HTML.Execute(&body, newsletter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Inline images are attachments in the email, serve them from disk instead
+		html := cidPattern.ReplaceAllString(body.String(), "/cid/$1?theme="+url.QueryEscape(t.Name))
+		html = strings.Replace(html, "</body>", liveReload+"</body>", 1)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(html))
+	})
+
+	mux.HandleFunc("/_version", func(w http.ResponseWriter, r *http.Request) {
+		// The latest modification of any file of the theme
+		var version int64
+		err := fs.WalkDir(theme.Dir(snap.themeName(r)), ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			version = max(version, info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, version)
+	})
+
+	mux.HandleFunc("/cid/", func(w http.ResponseWriter, r *http.Request) {
+		t, err := theme.Load(r.URL.Query().Get("theme"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dir, filename, err := findImage(inlineImages(t), path.Base(r.URL.Path))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := fs.ReadFile(dir, filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(filename)))
+		w.Write(data)
+	})
+
+	log.Info().Msgf("Previewing the newsletter on http://%s, add ?to=<email> to see a subscriber's version", addr)
+	return http.ListenAndServe(addr, mux)
+}
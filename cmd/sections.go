@@ -0,0 +1,174 @@
+package main
+
+import (
+	"newmusicrelease/album"
+	"slices"
+	"sort"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Keys of the sections, as used in the sections config.
+const (
+	sectionFamiliar  = "familiar"
+	sectionGenres    = "genres"
+	sectionSingles   = "singles"
+	sectionWildcards = "wildcards"
+)
+
+// Section is a group of albums rendered under its own heading.
+type Section struct {
+	// Key is familiar, genres, singles or wildcards, themes can use it to style the section.
+	Key    string
+	Title  string
+	Albums []album.Album
+}
+
+// Profile is what is known of the listening history of a Spotify account.
+type Profile struct {
+	// Genres are the top genres, the most listened first.
+	Genres []string
+	// Artists are the Spotify IDs of the top artists and of the artists of the top tracks.
+	Artists []string
+}
+
+// knows reports whether one of the artists of a is among the top artists of the profile.
+func (p Profile) knows(a album.Album) bool {
+	for _, artist := range a.Spotify.Artists {
+		if slices.Contains(p.Artists, artist.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+// allGenres returns the genres of every profile, without duplicates.
+func allGenres(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var genres []string
+	for _, name := range names {
+		for _, genre := range profiles[name].Genres {
+			if !slices.Contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+	}
+	return genres
+}
+
+// sectionLayout is the order of the sections and how many albums each of them holds.
+// A limit of 0 means no limit.
+type sectionLayout struct {
+	Order []string
+	// Limit applies to the sections missing from Limits. The limit of genres applies to
+	// every genre section.
+	Limit  int
+	Limits map[string]int
+}
+
+// sectionLayoutFromConfig reads the sections settings from the main config file.
+func sectionLayoutFromConfig() sectionLayout {
+	viper.SetDefault("sections.order", []string{sectionFamiliar, sectionGenres, sectionSingles, sectionWildcards})
+	viper.SetDefault("sections.limit", 0)
+
+	layout := sectionLayout{Limit: viper.GetInt("sections.limit"), Limits: make(map[string]int)}
+	for _, key := range viper.GetStringSlice("sections.order") {
+		switch key {
+		case sectionFamiliar, sectionGenres, sectionSingles, sectionWildcards:
+			layout.Order = append(layout.Order, key)
+		default:
+			log.Warn().Str("section", key).Msg("unknown section, it will be skipped")
+		}
+	}
+	for key := range viper.GetStringMap("sections.limits") {
+		layout.Limits[key] = viper.GetInt("sections.limits." + key)
+	}
+	return layout
+}
+
+func (l sectionLayout) limit(key string) int {
+	if limit, ok := l.Limits[key]; ok {
+		return limit
+	}
+	return l.Limit
+}
+
+// buildSections puts every album in the first section of the layout that accepts it and
+// isn't full yet. The albums matching the genres of the profile go in the familiar, genres
+// and singles sections, and the other ones in the wildcards. Albums no section has room
+// for are left out, and so are the sections that end up empty.
+func buildSections(albums []album.Album, profile Profile, layout sectionLayout) []Section {
+	type candidate struct {
+		section Section
+		limit   int
+		accepts func(album.Album) bool
+	}
+
+	inProfile := func(a album.Album) bool { return matchesAny(a.Genres, profile.Genres) }
+
+	var candidates []*candidate
+	for _, key := range layout.Order {
+		switch key {
+		case sectionFamiliar:
+			candidates = append(candidates, &candidate{
+				section: Section{Key: key, Title: "From artists you already listen to"},
+				limit:   layout.limit(key),
+				accepts: func(a album.Album) bool { return inProfile(a) && profile.knows(a) },
+			})
+		case sectionGenres:
+			for _, genre := range profile.Genres {
+				genre := genre
+				candidates = append(candidates, &candidate{
+					section: Section{Key: key, Title: capitalize(genre)},
+					limit:   layout.limit(key),
+					accepts: func(a album.Album) bool { return matchesAny(a.Genres, []string{genre}) },
+				})
+			}
+		case sectionSingles:
+			candidates = append(candidates, &candidate{
+				section: Section{Key: key, Title: "EPs and singles"},
+				limit:   layout.limit(key),
+				accepts: func(a album.Album) bool { return inProfile(a) && a.IsSingle() },
+			})
+		case sectionWildcards:
+			candidates = append(candidates, &candidate{
+				section: Section{Key: key, Title: "Wildcards"},
+				limit:   layout.limit(key),
+				accepts: func(a album.Album) bool { return !inProfile(a) },
+			})
+		}
+	}
+
+	for _, a := range albums {
+		for _, c := range candidates {
+			if c.accepts(a) && (c.limit == 0 || len(c.section.Albums) < c.limit) {
+				c.section.Albums = append(c.section.Albums, a)
+				break
+			}
+		}
+	}
+
+	var sections []Section
+	for _, c := range candidates {
+		if len(c.section.Albums) > 0 {
+			sections = append(sections, c.section)
+		}
+	}
+	return sections
+}
+
+func capitalize(s string) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"newmusicrelease/history"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// site is the server the links of the newsletter lead to, see serve. The links are
+// signed with Secret so they can't be changed to act for another subscriber or to
+// redirect anywhere else.
+type site struct {
+	BaseURL string
+	Secret  []byte
+}
+
+// siteFromConfig returns the site of the server settings, nil when server.base_url isn't set.
+// The tracking.base_url and tracking.secret settings of before are still read.
+func siteFromConfig() (*site, error) {
+	for _, key := range []string{"base_url", "secret"} {
+		if !viper.IsSet("server."+key) && viper.IsSet("tracking."+key) {
+			log.Warn().Msgf("tracking.%s is deprecated, move it to server.%s", key, key)
+			viper.Set("server."+key, viper.Get("tracking."+key))
+		}
+	}
+
+	s := &site{
+		BaseURL: strings.TrimSuffix(viper.GetString("server.base_url"), "/"),
+		Secret:  []byte(viper.GetString("server.secret")),
+	}
+	if s.BaseURL == "" {
+		return nil, nil
+	}
+	if len(s.Secret) == 0 {
+		return nil, errors.New("server.secret is needed to sign the links of server.base_url")
+	}
+	return s, nil
+}
+
+// link is the URL of path on the site with the query signed.
+func (s *site) link(path string, query url.Values) string {
+	query.Set("sig", s.sign(query))
+	return s.BaseURL + path + "?" + query.Encode()
+}
+
+// sign is the signature of the query, without its sig parameter.
+func (s *site) sign(query url.Values) string {
+	unsigned := url.Values{}
+	for key, values := range query {
+		if key != "sig" {
+			unsigned[key] = values
+		}
+	}
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(unsigned.Encode()))
+	// Half of the signature is plenty and keeps the links short
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (s *site) verify(query url.Values) bool {
+	return hmac.Equal([]byte(query.Get("sig")), []byte(s.sign(query)))
+}
+
+// serve answers the links of the newsletter on addr until the program is stopped: the
+// unsubscribe and preferences pages, and the tracked links when tracking is enabled.
+func serve(s *site, t *tracker, subscribersFile string, addr string) error {
+	if s == nil {
+		return errors.New("set server.base_url to the address the server is reachable at")
+	}
+
+	store := history.FromConfig()
+	mux := http.NewServeMux()
+	if t != nil {
+		t.register(mux, store)
+	}
+	subscriptions := &subscriptions{site: s, file: subscribersFile, store: store}
+	subscriptions.register(mux)
+
+	log.Info().Str("addr", addr).Str("base_url", s.BaseURL).Bool("tracking", t != nil).Msg("Serving the links of the newsletter")
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"newmusicrelease/album"
+	"newmusicrelease/cover"
+	"strings"
+)
+
+// slackMaxBlocks is how many blocks Slack accepts in a message.
+const slackMaxBlocks = 50
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// slackBlock is a Block Kit block, see https://api.slack.com/reference/block-kit/blocks
+type slackBlock struct {
+	Type      string      `json:"type"`
+	Text      *slackText  `json:"text,omitempty"`
+	Accessory *slackImage `json:"accessory,omitempty"`
+	Elements  []slackText `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	// Text is shown in the notifications, where blocks aren't rendered.
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackMarkdown(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: text}
+}
+
+// slackPayloads builds a message with a header, a block per section title and a block per
+// album with its cover. The albums past the block limit of Slack are counted at the end.
+func slackPayloads(newsletter Newsletter) ([]any, error) {
+	title := fmt.Sprintf("New Music Friday – %s", newsletter.Date.Format("January 2"))
+	message := slackMessage{Text: title}
+	message.Blocks = append(message.Blocks, slackBlock{Type: "header", Text: &slackText{Type: "plain_text", Text: title}})
+
+	var playlists []string
+	for _, playlist := range newsletter.Playlists {
+		if newsletter.Subscriber.WantsPlatform(playlist.Platform) {
+			playlists = append(playlists, fmt.Sprintf("<%s|%s>", playlist.URL, playlist.Platform))
+		}
+	}
+	if len(playlists) > 0 {
+		message.Blocks = append(message.Blocks, slackBlock{Type: "section", Text: slackMarkdown("Playlist: " + strings.Join(playlists, " · "))})
+	}
+	if len(newsletter.DegradedPlatforms) > 0 {
+		message.Blocks = append(message.Blocks, slackBlock{
+			Type:     "context",
+			Elements: []slackText{*slackMarkdown("Links may be missing this week for: " + strings.Join(newsletter.DegradedPlatforms, ", ") + ".")},
+		})
+	}
+
+	written := 0
+sections:
+	for _, section := range newsletter.Sections {
+		for i, a := range section.Albums {
+			blocks, err := slackAlbum(a, newsletter)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				heading := slackBlock{Type: "header", Text: &slackText{Type: "plain_text", Text: section.Title}}
+				blocks = append([]slackBlock{heading}, blocks...)
+			}
+			// Keep a block to tell how many albums were left out
+			if len(message.Blocks)+len(blocks) > slackMaxBlocks-1 {
+				break sections
+			}
+			message.Blocks = append(message.Blocks, blocks...)
+			written++
+		}
+	}
+	if left := len(newsletter.Albums) - written; left > 0 {
+		message.Blocks = append(message.Blocks, slackBlock{
+			Type:     "context",
+			Elements: []slackText{*slackMarkdown(fmt.Sprintf("…and %d more", left))},
+		})
+	}
+	return []any{message}, nil
+}
+
+func slackAlbum(a album.Album, newsletter Newsletter) ([]slackBlock, error) {
+	links, err := albumLinks(a, newsletter.Subscriber)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("*%s* — %s", slackEscaper.Replace(a.AlbumName), slackEscaper.Replace(a.ArtistName))
+	if len(links) > 0 {
+		urls := make([]string, 0, len(links))
+		for _, l := range links {
+			urls = append(urls, fmt.Sprintf("<%s|%s>", l.URL, l.Platform))
+		}
+		text += "\n" + strings.Join(urls, " · ")
+	}
+	if len(a.Genres) > 0 {
+		text += "\n" + genreTags(a.Genres)
+	}
+
+	block := slackBlock{Type: "section", Text: slackMarkdown(text)}
+	if url := coverURL(a); url != "" {
+		block.Accessory = &slackImage{Type: "image", ImageURL: url, AltText: a.AlbumName}
+	}
+	return []slackBlock{block}, nil
+}
+
+// coverURL is the largest cover of the album on the platforms, for the channels that
+// link images instead of attaching them.
+func coverURL(a album.Album) string {
+	candidates := cover.Candidates(a)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"newmusicrelease/history"
+	"newmusicrelease/provider"
+	"newmusicrelease/subscriber"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// genreWeeks is how many of the last weeks the genres offered on the preferences page
+// are taken from, and genreOptions how many of them are offered at most.
+const (
+	genreWeeks   = 4
+	genreOptions = 30
+)
+
+// subscriptions lets the subscribers unsubscribe and change their preferences from the
+// links of the newsletter, saving the changes in the subscribers file.
+type subscriptions struct {
+	site  *site
+	file  string
+	store *history.Store
+	// mu keeps two changes from loading the file at the same time and losing one of them.
+	mu sync.Mutex
+}
+
+// unsubscribeURL and preferencesURL are the links of the newsletter of the subscriber
+// with the given ID.
+func (s *site) unsubscribeURL(id string) string {
+	return s.link("/unsubscribe", url.Values{"s": {id}})
+}
+
+func (s *site) preferencesURL(id string) string {
+	return s.link("/preferences", url.Values{"s": {id}})
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>New Music Friday</title></head>
+<body style="font-family: sans-serif; max-width: 36em; margin: 4em auto; padding: 0 1em">
+{{if .Done}}
+<p>You won't get New Music Friday anymore.</p>
+{{else}}
+<form method="post">
+<p>Stop getting New Music Friday?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+<p>Or <a href="{{.Preferences}}">get it less often</a>.</p>
+{{end}}
+</body>
+</html>`))
+
+var preferencesPage = template.Must(template.New("preferences").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>New Music Friday preferences</title></head>
+<body style="font-family: sans-serif; max-width: 36em; margin: 4em auto; padding: 0 1em">
+<h1>New Music Friday</h1>
+{{if .Saved}}<p><strong>Your preferences are saved.</strong></p>{{end}}
+<form method="post">
+<h2>Genres</h2>
+<p>Only the albums of the genres checked, every album when none is.</p>
+{{range .Genres}}<label style="display: inline-block; margin-right: 1em"><input type="checkbox" name="genre" value="{{.Name}}"{{if .Checked}} checked{{end}}> {{.Name}}</label>
+{{end}}
+<h2>Platforms</h2>
+<p>The links to show, every platform when none is checked.</p>
+{{range .Platforms}}<label style="display: inline-block; margin-right: 1em"><input type="checkbox" name="platform" value="{{.Name}}"{{if .Checked}} checked{{end}}> {{.Name}}</label>
+{{end}}
+<h2>Frequency</h2>
+{{range .Frequencies}}<label style="display: block"><input type="radio" name="frequency" value="{{.Name}}"{{if .Checked}} checked{{end}}> {{.Name}}</label>
+{{end}}
+<p><button type="submit">Save</button></p>
+</form>
+<p><a href="{{.Unsubscribe}}">Unsubscribe</a></p>
+</body>
+</html>`))
+
+type option struct {
+	Name    string
+	Checked bool
+}
+
+func options(names []string, checked func(name string) bool) []option {
+	opts := make([]option, 0, len(names))
+	for _, name := range names {
+		opts = append(opts, option{Name: name, Checked: checked(name)})
+	}
+	return opts
+}
+
+// register serves the pages on mux:
+//
+//   - /unsubscribe asks to confirm, and removes the subscriber on POST, which is also what
+//     mail clients send for the one-click unsubscribe of RFC 8058
+//   - /preferences shows the genres, platforms and frequency of the subscriber, and saves
+//     them on POST
+func (subs *subscriptions) register(mux *http.ServeMux) {
+	mux.HandleFunc("/unsubscribe", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !subs.site.verify(query) {
+			http.Error(w, "invalid link", http.StatusBadRequest)
+			return
+		}
+		id := query.Get("s")
+
+		page := struct {
+			Done        bool
+			Preferences string
+		}{Preferences: subs.site.preferencesURL(id)}
+
+		if r.Method == http.MethodPost {
+			err := subs.update(id, func(registry *subscriber.Registry, i int) {
+				registry.Subscribers = slices.Delete(registry.Subscribers, i, i+1)
+			})
+			if err != nil {
+				log.Error().Err(err).Str("subscriber", id).Msg("error encountered while unsubscribing")
+				http.Error(w, "The unsubscribe failed, try again later.", http.StatusInternalServerError)
+				return
+			}
+			log.Info().Str("subscriber", id).Msg("Unsubscribed")
+			page.Done = true
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		unsubscribePage.Execute(w, page)
+	})
+
+	mux.HandleFunc("/preferences", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !subs.site.verify(query) {
+			http.Error(w, "invalid link", http.StatusBadRequest)
+			return
+		}
+		id := query.Get("s")
+
+		saved := false
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			frequency := r.PostForm.Get("frequency")
+			if frequency != "" && !slices.Contains(subscriber.Frequencies, frequency) {
+				http.Error(w, "unknown frequency", http.StatusBadRequest)
+				return
+			}
+
+			err = subs.update(id, func(registry *subscriber.Registry, i int) {
+				registry.Subscribers[i].Genres = r.PostForm["genre"]
+				registry.Subscribers[i].Platforms = r.PostForm["platform"]
+				registry.Subscribers[i].Frequency = frequency
+			})
+			if err != nil {
+				log.Error().Err(err).Str("subscriber", id).Msg("error encountered while saving the preferences")
+				http.Error(w, "The preferences couldn't be saved, try again later.", http.StatusInternalServerError)
+				return
+			}
+			log.Info().Str("subscriber", id).Strs("genres", r.PostForm["genre"]).Strs("platforms", r.PostForm["platform"]).Str("frequency", frequency).Msg("Preferences saved")
+			saved = true
+		}
+
+		subs.mu.Lock()
+		registry, err := subscriber.Load(subs.file)
+		subs.mu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Msg("error encountered while loading the subscribers")
+			http.Error(w, "The preferences couldn't be loaded, try again later.", http.StatusInternalServerError)
+			return
+		}
+		i, ok := registry.Find(id)
+		if !ok {
+			http.Error(w, "You are not subscribed anymore.", http.StatusNotFound)
+			return
+		}
+		s := registry.Subscribers[i]
+
+		frequency := s.Frequency
+		if frequency == "" {
+			frequency = subscriber.Weekly
+		}
+		page := struct {
+			Saved       bool
+			Genres      []option
+			Platforms   []option
+			Frequencies []option
+			Unsubscribe string
+		}{
+			Saved:       saved,
+			Genres:      options(subs.genres(s.Genres), func(name string) bool { return slices.Contains(s.Genres, name) }),
+			Platforms:   options([]string{provider.Spotify, provider.Tidal, provider.Deezer}, func(name string) bool { return slices.Contains(s.Platforms, name) }),
+			Frequencies: options(subscriber.Frequencies, func(name string) bool { return name == frequency }),
+			Unsubscribe: subs.site.unsubscribeURL(id),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		preferencesPage.Execute(w, page)
+	})
+}
+
+// update loads the subscribers file, changes the subscriber with the given ID with fn and
+// saves the file. Nothing is saved when there is no such subscriber anymore.
+func (subs *subscriptions) update(id string, fn func(registry *subscriber.Registry, i int)) error {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	registry, err := subscriber.Load(subs.file)
+	if err != nil {
+		return err
+	}
+	i, ok := registry.Find(id)
+	if !ok {
+		return nil
+	}
+	fn(registry, i)
+	return registry.Save()
+}
+
+// genres are the genres offered on the preferences page: the most common ones of the
+// last weeks, and the ones the subscriber already picked.
+func (subs *subscriptions) genres(picked []string) []string {
+	weeks, err := subs.store.Weeks()
+	if err != nil {
+		log.Error().Err(err).Msg("error encountered while reading the history")
+	}
+
+	counts := make(map[string]int)
+	for i, w := range weeks {
+		if i >= genreWeeks {
+			break
+		}
+		for _, a := range w.Albums {
+			for _, genre := range a.Genres {
+				counts[genre]++
+			}
+		}
+	}
+	common := make([]string, 0, len(counts))
+	for genre := range counts {
+		common = append(common, genre)
+	}
+	sort.Slice(common, func(i, j int) bool {
+		if counts[common[i]] != counts[common[j]] {
+			return counts[common[i]] > counts[common[j]]
+		}
+		return common[i] < common[j]
+	})
+	if len(common) > genreOptions {
+		common = common[:genreOptions]
+	}
+
+	genres := append(common, picked...)
+	sort.Strings(genres)
+	return slices.Compact(genres)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"newmusicrelease/album"
+	"newmusicrelease/history"
+	"newmusicrelease/subscriber"
+	"newmusicrelease/telegram"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// telegramCaptionLength is the most characters Telegram shows under a photo.
+const telegramCaptionLength = 1024
+
+// reactionButtons are the buttons under every album card, pressing one records the
+// reaction in the history.
+var reactionButtons = []struct {
+	Kind string
+	Text string
+	// Answer is shown for a moment once the reaction is recorded.
+	Answer string
+}{
+	{history.Like, "👍 Like", "Noted, more like this"},
+	{history.Dislike, "👎 Not for me", "Noted, less like this"},
+	{history.Heard, "✓ Already heard", "Noted, you already heard it"},
+}
+
+// telegramCall is a Bot API method and its parameters, as written for a dry run.
+type telegramCall struct {
+	Method string
+	Params any
+}
+
+// telegramNotifier posts a message with the playlists, then a card per album: its cover,
+// its links and the reaction buttons.
+type telegramNotifier struct {
+	bot    *telegram.Bot
+	chatID string
+	// DryRunFile, when set, is where the calls are written instead of being sent.
+	DryRunFile string
+}
+
+func (n *telegramNotifier) Notify(newsletter Newsletter) error {
+	if len(newsletter.Albums) == 0 {
+		return fmt.Errorf("%w %v", errNoAlbums, newsletter.Subscriber.Genres)
+	}
+
+	calls, err := telegramCalls(newsletter, n.chatID)
+	if err != nil {
+		return err
+	}
+
+	if n.DryRunFile != "" {
+		data, err := json.MarshalIndent(calls, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(n.DryRunFile, data, 0o644)
+	}
+
+	for _, call := range calls {
+		err = n.bot.Call(call.Method, call.Params, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func telegramCalls(newsletter Newsletter, chatID string) ([]telegramCall, error) {
+	text := fmt.Sprintf("<b>New Music Friday – %s</b>", newsletter.Date.Format("January 2"))
+	var playlists []string
+	for _, playlist := range newsletter.Playlists {
+		if newsletter.Subscriber.WantsPlatform(playlist.Platform) {
+			playlists = append(playlists, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(playlist.URL), playlist.Platform))
+		}
+	}
+	if len(playlists) > 0 {
+		text += "\nPlaylist: " + strings.Join(playlists, " · ")
+	}
+	if len(newsletter.DegradedPlatforms) > 0 {
+		text += "\n<i>Links may be missing this week for: " + html.EscapeString(strings.Join(newsletter.DegradedPlatforms, ", ")) + ".</i>"
+	}
+
+	calls := []telegramCall{{
+		Method: "sendMessage",
+		Params: telegram.SendMessage{ChatID: chatID, Text: text, ParseMode: "HTML", DisablePreview: true},
+	}}
+
+	week := history.Week{Date: newsletter.Date}.ID()
+	for _, section := range newsletter.Sections {
+		for _, a := range section.Albums {
+			links, err := albumLinks(a, newsletter.Subscriber)
+			if err != nil {
+				return nil, err
+			}
+
+			caption := telegramCaption(a, section.Title)
+			keyboard := telegramKeyboard(a, links, week)
+
+			// The cards don't ring, only the first message does
+			url := coverURL(a)
+			if url == "" {
+				calls = append(calls, telegramCall{Method: "sendMessage", Params: telegram.SendMessage{
+					ChatID: chatID, Text: caption, ParseMode: "HTML", DisablePreview: true, ReplyMarkup: keyboard, DisableNotification: true,
+				}})
+				continue
+			}
+			calls = append(calls, telegramCall{Method: "sendPhoto", Params: telegram.SendPhoto{
+				ChatID: chatID, Photo: url, Caption: caption, ParseMode: "HTML", ReplyMarkup: keyboard, DisableNotification: true,
+			}})
+		}
+	}
+	return calls, nil
+}
+
+func telegramCaption(a album.Album, section string) string {
+	name := []rune(a.AlbumName)
+	if len(name) > telegramCaptionLength/2 {
+		name = append(name[:telegramCaptionLength/2], '…')
+	}
+	caption := fmt.Sprintf("<b>%s</b> — %s", html.EscapeString(string(name)), html.EscapeString(a.ArtistName))
+	if len(a.Genres) > 0 {
+		caption += "\n" + html.EscapeString(genreTags(a.Genres))
+	}
+	caption += "\n<i>" + html.EscapeString(section) + "</i>"
+
+	// The genres are what makes it too long, if anything
+	if utf8.RuneCountInString(caption) > telegramCaptionLength {
+		caption = fmt.Sprintf("<b>%s</b> — %s", html.EscapeString(string(name)), html.EscapeString(a.ArtistName))
+	}
+	return caption
+}
+
+// telegramKeyboard has a row with the links of the album and one with the reactions,
+// left out when the album has no key to record them with.
+func telegramKeyboard(a album.Album, links []link, week string) *telegram.InlineKeyboard {
+	keyboard := &telegram.InlineKeyboard{}
+	var row []telegram.InlineButton
+	for _, l := range links {
+		row = append(row, telegram.InlineButton{Text: l.Platform, URL: l.URL})
+	}
+	if len(row) > 0 {
+		keyboard.Rows = append(keyboard.Rows, row)
+	}
+
+	if key := a.Key(); key != "" {
+		row = nil
+		for _, button := range reactionButtons {
+			row = append(row, telegram.InlineButton{Text: button.Text, CallbackData: reactionData(button.Kind, week, key)})
+		}
+		keyboard.Rows = append(keyboard.Rows, row)
+	}
+
+	if len(keyboard.Rows) == 0 {
+		return nil
+	}
+	return keyboard
+}
+
+// reactionData is what a reaction button sends back, e.g. like:2024-01-05:spotify:<id>.
+// Telegram allows 64 bytes.
+func reactionData(kind string, week string, albumKey string) string {
+	return kind + ":" + week + ":" + albumKey
+}
+
+func parseReactionData(data string) (kind string, week string, albumKey string, err error) {
+	parts := strings.SplitN(data, ":", 3)
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("unexpected callback data %q", data)
+	}
+	// The week names a file of the history
+	if _, err := time.Parse("2006-01-02", parts[1]); err != nil {
+		return "", "", "", fmt.Errorf("unexpected week in callback data %q", data)
+	}
+	for _, button := range reactionButtons {
+		if button.Kind == parts[0] {
+			return parts[0], parts[1], parts[2], nil
+		}
+	}
+	return "", "", "", fmt.Errorf("unknown reaction %q", parts[0])
+}
+
+// reactionRecorder records the reaction buttons pressed by the telegram subscribers in
+// the week of the history the card was posted for.
+type reactionRecorder struct {
+	bot         *telegram.Bot
+	store       *history.Store
+	subscribers []subscriber.Subscriber
+}
+
+func (rr *reactionRecorder) handle(u telegram.Update) error {
+	query := u.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil
+	}
+
+	// Trying again wouldn't make the data any better, let it go
+	kind, week, albumKey, err := parseReactionData(query.Data)
+	if err != nil {
+		log.Warn().Err(err).Msg("reaction ignored")
+		return rr.bot.AnswerCallbackQuery(query.ID, "")
+	}
+
+	chatID := strconv.FormatInt(query.Message.Chat.ID, 10)
+	var s subscriber.Subscriber
+	found := false
+	for _, candidate := range rr.subscribers {
+		if candidate.GetChannel() == subscriber.ChannelTelegram && candidate.ChatID == chatID {
+			s, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		log.Warn().Str("chat_id", chatID).Msg("reaction from a chat no subscriber is in")
+		return rr.bot.AnswerCallbackQuery(query.ID, "This chat doesn't get the newsletter anymore")
+	}
+
+	err = rr.store.React(week, history.Reaction{Subscriber: s.ID(), Album: albumKey, Kind: kind, At: time.Now().UTC()})
+	if errors.Is(err, fs.ErrNotExist) {
+		return rr.bot.AnswerCallbackQuery(query.ID, "This week is not in the history anymore")
+	}
+	if err != nil {
+		return err
+	}
+	log.Info().Str("subscriber", s.ID()).Str("album", albumKey).Str("reaction", kind).Str("week", week).Msg("Reaction recorded")
+
+	for _, button := range reactionButtons {
+		if button.Kind == kind {
+			return rr.bot.AnswerCallbackQuery(query.ID, button.Answer)
+		}
+	}
+	return nil
+}
+
+// pollReactions asks Telegram for the buttons pressed until the program is stopped. It
+// only works while no webhook is set for the bot. An update that couldn't be recorded is
+// asked for again, along the ones after it.
+func pollReactions(rr *reactionRecorder) error {
+	var offset int64
+	for {
+		updates, err := rr.bot.GetUpdates(offset, 50*time.Second)
+		if err != nil {
+			log.Error().Err(err).Msg("error encountered while polling Telegram")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			err = rr.handle(u)
+			if err != nil {
+				// Telegram hands the update again as long as the offset doesn't move past it,
+				// recording a reaction twice only replaces it
+				log.Error().Err(err).Int64("update_id", u.UpdateID).Msg("error encountered while recording the reaction, trying again")
+				time.Sleep(5 * time.Second)
+				break
+			}
+			offset = u.UpdateID + 1
+		}
+	}
+}
+
+// serveReactions receives the buttons pressed on addr, at /telegram. When
+// telegram.webhook_url is set, Telegram is told to post the updates there. The requests
+// have to carry telegram.webhook_secret.
+func serveReactions(rr *reactionRecorder, addr string) error {
+	secret := viper.GetString("telegram.webhook_secret")
+	if secret == "" {
+		return errors.New("telegram.webhook_secret is needed to check the updates come from Telegram")
+	}
+
+	if url := viper.GetString("telegram.webhook_url"); url != "" {
+		err := rr.bot.SetWebhook(url, secret)
+		if err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/telegram", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != secret {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var u telegram.Update
+		err := json.NewDecoder(r.Body).Decode(&u)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Telegram sends the update again until it gets a 200, only do so once it is recorded
+		err = rr.handle(u)
+		if err != nil {
+			log.Error().Err(err).Int64("update_id", u.UpdateID).Msg("error encountered while recording the reaction")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	log.Info().Str("addr", addr).Msg("Waiting for the reactions from Telegram")
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// renderText renders the text/plain alternative of the newsletter. When there is no
+// text template, a plain listing of the sections, their albums and links is generated instead.
+func renderText(tmpl *template.Template, newsletter Newsletter) ([]byte, error) {
+	var body bytes.Buffer
+	if tmpl != nil {
+		err := tmpl.Execute(&body, newsletter)
+		return body.Bytes(), err
+	}
+
+	fmt.Fprintf(&body, "New Music Friday – %s\n", newsletter.Date.Format("January 2"))
+	for _, playlist := range newsletter.Playlists {
+		if newsletter.Subscriber.WantsPlatform(playlist.Platform) {
+			fmt.Fprintf(&body, "Playlist on %s: %s\n", playlist.Platform, playlist.URL)
+		}
+	}
+	if len(newsletter.DegradedPlatforms) > 0 {
+		fmt.Fprintf(&body, "Links may be missing this week for: %s.\n", strings.Join(newsletter.DegradedPlatforms, ", "))
+	}
+
+	for _, section := range newsletter.Sections {
+		fmt.Fprintf(&body, "\n== %s ==\n", section.Title)
+		for _, a := range section.Albums {
+			fmt.Fprintf(&body, "\n%s — %s\n", a.AlbumName, a.ArtistName)
+			if len(a.Genres) > 0 {
+				fmt.Fprintf(&body, "Genres: %s\n", strings.Join(a.Genres, ", "))
+			}
+			links, err := albumLinks(a, newsletter.Subscriber)
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range links {
+				fmt.Fprintf(&body, "%s: %s\n", l.Platform, l.URL)
+			}
+			if a.Feedback.More != "" {
+				fmt.Fprintf(&body, "More like this: %s\nLess like this: %s\n", a.Feedback.More, a.Feedback.Less)
+			}
+		}
+	}
+	if newsletter.Unsubscribe != "" {
+		fmt.Fprintf(&body, "\nPreferences: %s\nUnsubscribe: %s\n", newsletter.Preferences, newsletter.Unsubscribe)
+	}
+	return body.Bytes(), nil
+}
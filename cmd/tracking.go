@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"newmusicrelease/album"
+	"newmusicrelease/history"
+	"newmusicrelease/subscriber"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// tracker rewrites the links of the newsletter so they go through the site first, which
+// records the clicks and the feedback of every subscriber in the history.
+type tracker struct {
+	site *site
+}
+
+// trackerFromConfig returns the tracker of the tracking settings, nil when the links are
+// left alone.
+func trackerFromConfig(s *site) (*tracker, error) {
+	viper.SetDefault("tracking.enabled", false)
+	if !viper.GetBool("tracking.enabled") {
+		return nil, nil
+	}
+	if s == nil {
+		return nil, errors.New("tracking needs server.base_url, the address of `serve`")
+	}
+	return &tracker{site: s}, nil
+}
+
+// track returns the newsletter with the links of its albums going through the tracker,
+// and with their feedback links. The albums without a key are left alone.
+func (t *tracker) track(newsletter Newsletter) (Newsletter, error) {
+	week := history.Week{Date: newsletter.Date}.ID()
+	id := newsletter.Subscriber.ID()
+
+	var err error
+	tracked := newsletter
+	tracked.Albums, err = t.albums(newsletter.Albums, id, week)
+	if err != nil {
+		return newsletter, err
+	}
+	tracked.Sections = make([]Section, len(newsletter.Sections))
+	for i, section := range newsletter.Sections {
+		tracked.Sections[i] = section
+		tracked.Sections[i].Albums, err = t.albums(section.Albums, id, week)
+		if err != nil {
+			return newsletter, err
+		}
+	}
+	return tracked, nil
+}
+
+// albums returns copies of the albums with their links rewritten for the subscriber.
+func (t *tracker) albums(albums []album.Album, id string, week string) ([]album.Album, error) {
+	tracked := make([]album.Album, 0, len(albums))
+	for _, a := range albums {
+		key := a.Key()
+		if key == "" {
+			tracked = append(tracked, a)
+			continue
+		}
+
+		links := make(map[string]string)
+		platformLinks, err := albumLinks(a, subscriber.Subscriber{})
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range platformLinks {
+			links[l.Platform] = t.site.link("/click", url.Values{
+				"s": {id}, "w": {week}, "a": {key}, "p": {l.Platform}, "u": {l.URL},
+			})
+		}
+
+		a.Links = links
+		a.Feedback = album.Feedback{
+			More: t.site.link("/feedback", url.Values{"s": {id}, "w": {week}, "a": {key}, "k": {history.Like}}),
+			Less: t.site.link("/feedback", url.Values{"s": {id}, "w": {week}, "a": {key}, "k": {history.Dislike}}),
+		}
+		tracked = append(tracked, a)
+	}
+	return tracked, nil
+}
+
+var feedbackPage = template.Must(template.New("feedback").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>New Music Friday</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 4em">
+{{if .Done}}
+<p>Thanks, the next newsletters will have {{if .More}}more{{else}}less{{end}} albums like this one.</p>
+{{else}}
+<form method="post">
+<p>Do you want {{if .More}}more{{else}}less{{end}} albums like this one?</p>
+<button type="submit">{{if .More}}More like this{{else}}Less like this{{end}}</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+// register serves the links of the tracker on mux, recording what they tell in store:
+//
+//   - /click records the click and redirects to the platform
+//   - /feedback asks to confirm, so link scanners opening every link of an email don't
+//     answer for the subscriber, then records the reaction
+func (t *tracker) register(mux *http.ServeMux, store *history.Store) {
+	mux.HandleFunc("/click", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target, err := url.Parse(query.Get("u"))
+		if !t.site.verify(query) || err != nil || (target.Scheme != "https" && target.Scheme != "http") {
+			http.Error(w, "invalid link", http.StatusBadRequest)
+			return
+		}
+
+		// The subscriber gets to the album even when the click can't be recorded
+		err = store.Click(query.Get("w"), history.Click{
+			Subscriber: query.Get("s"),
+			Album:      query.Get("a"),
+			Platform:   query.Get("p"),
+			At:         time.Now().UTC(),
+		})
+		if err != nil {
+			log.Error().Err(err).Str("week", query.Get("w")).Msg("error encountered while recording the click")
+		} else {
+			log.Info().Str("subscriber", query.Get("s")).Str("album", query.Get("a")).Str("platform", query.Get("p")).Msg("Click recorded")
+		}
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	})
+
+	mux.HandleFunc("/feedback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		kind := query.Get("k")
+		if !t.site.verify(query) || (kind != history.Like && kind != history.Dislike) {
+			http.Error(w, "invalid link", http.StatusBadRequest)
+			return
+		}
+
+		page := struct {
+			More bool
+			Done bool
+		}{More: kind == history.Like}
+
+		if r.Method == http.MethodPost {
+			err := store.React(query.Get("w"), history.Reaction{
+				Subscriber: query.Get("s"),
+				Album:      query.Get("a"),
+				Kind:       kind,
+				At:         time.Now().UTC(),
+			})
+			if errors.Is(err, fs.ErrNotExist) {
+				http.Error(w, "This week is not in the history anymore.", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Str("week", query.Get("w")).Msg("error encountered while recording the feedback")
+				http.Error(w, "The feedback couldn't be recorded, try again later.", http.StatusInternalServerError)
+				return
+			}
+			log.Info().Str("subscriber", query.Get("s")).Str("album", query.Get("a")).Str("reaction", kind).Msg("Feedback recorded")
+			page.Done = true
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		feedbackPage.Execute(w, page)
+	})
+}
@@ -0,0 +1,178 @@
+// Package cover downloads album covers and shrinks them so they can be sent inline with
+// the newsletter instead of being hot-linked.
+package cover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"newmusicrelease/album"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/image/draw"
+)
+
+// Placeholder is the file name of the cover used when none could be downloaded.
+const Placeholder = "cover-placeholder.jpg"
+
+type candidate struct {
+	URL   string
+	Width int
+}
+
+// Candidates returns the known cover URLs of the album, the largest first.
+func Candidates(a album.Album) []string {
+	var candidates []candidate
+	for _, image := range a.Spotify.Images {
+		candidates = append(candidates, candidate{image.URL, image.Width})
+	}
+	for _, image := range a.Tidal.ImageCover {
+		candidates = append(candidates, candidate{image.URL, image.Width})
+	}
+	// Deezer sizes are documented at https://developers.deezer.com/api/album
+	for _, deezer := range []candidate{{a.Deezer.CoverXL, 1000}, {a.Deezer.CoverBig, 500}, {a.Deezer.CoverMedium, 250}} {
+		if deezer.URL != "" {
+			candidates = append(candidates, deezer)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Width > candidates[j].Width
+	})
+
+	urls := make([]string, 0, len(candidates)+1)
+	for _, c := range candidates {
+		if c.URL != "" {
+			urls = append(urls, c.URL)
+		}
+	}
+	// The scraped album art is small and its size unknown, it is the last resort
+	if a.AlbumArt != "" {
+		urls = append(urls, a.AlbumArt)
+	}
+	return urls
+}
+
+type Pipeline struct {
+	CacheDir string
+	Width    int
+	Quality  int
+	client   *http.Client
+}
+
+// FromConfig reads the cover settings from the main config file.
+func FromConfig() *Pipeline {
+	viper.SetDefault("covers.cache_dir", "cache/covers")
+	viper.SetDefault("covers.width", 300)
+	viper.SetDefault("covers.quality", 80)
+
+	return &Pipeline{
+		CacheDir: viper.GetString("covers.cache_dir"),
+		Width:    viper.GetInt("covers.width"),
+		Quality:  viper.GetInt("covers.quality"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Fetch makes sure a resized cover of the album is in the cache directory and returns its
+// file name, which is also the cid: used to reference it. The placeholder is returned when
+// none of the candidates could be downloaded.
+func (p *Pipeline) Fetch(a album.Album) (string, error) {
+	err := os.MkdirAll(p.CacheDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	for _, url := range Candidates(a) {
+		name := p.cacheName(url)
+		if _, err := os.Stat(filepath.Join(p.CacheDir, name)); err == nil {
+			return name, nil
+		}
+
+		err = p.download(url, name)
+		if err != nil {
+			log.Warn().Err(err).Str("url", url).Str("album_name", a.AlbumName).Msg("could not download cover")
+			continue
+		}
+		return name, nil
+	}
+
+	return Placeholder, p.placeholder()
+}
+
+func (p *Pipeline) cacheName(url string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s@%d", url, p.Width)))
+	return "cover-" + hex.EncodeToString(sum[:8]) + ".jpg"
+}
+
+func (p *Pipeline) download(url string, name string) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cover: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(resp.Body, 20<<20))
+	if err != nil {
+		return err
+	}
+
+	return p.write(name, resize(img, p.Width))
+}
+
+// resize scales img down to width, keeping its aspect ratio. Smaller images are kept as is.
+func resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// write encodes img as JPEG in the cache directory. The file is renamed into place once
+// complete so an interrupted run never leaves a truncated cover behind.
+func (p *Pipeline) write(name string, img image.Image) error {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.Quality})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(p.CacheDir, name+".tmp")
+	err = os.WriteFile(tmp, buf.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(p.CacheDir, name))
+}
+
+func (p *Pipeline) placeholder() error {
+	_, err := os.Stat(filepath.Join(p.CacheDir, Placeholder))
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, p.Width, p.Width))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0xd0, 0xd7, 0xde, 0xff}}, image.Point{}, draw.Src)
+	return p.write(Placeholder, img)
+}
@@ -0,0 +1,81 @@
+package deezer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"newmusicrelease/provider"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// AuthURL is the page where the user allows the app to manage their playlists. Deezer
+// sends them back to redirectURI with a code to give to Exchange, and with state, which
+// the caller checks so a code it didn't ask for isn't accepted. The offline_access
+// permission makes the access token last until the user revokes it.
+func AuthURL(redirectURI string, state string) string {
+	query := url.Values{}
+	query.Set("app_id", viper.GetString("deezer.app_id"))
+	query.Set("redirect_uri", redirectURI)
+	query.Set("state", state)
+	query.Set("perms", "basic_access,manage_library,offline_access")
+	return "https://connect.deezer.com/oauth/auth.php?" + query.Encode()
+}
+
+// Exchange trades the code Deezer redirected the user with for an access token, which is
+// saved in the config file.
+func Exchange(code string) error {
+	query := url.Values{}
+	query.Set("app_id", viper.GetString("deezer.app_id"))
+	query.Set("secret", viper.GetString("deezer.secret"))
+	query.Set("code", code)
+	query.Set("output", "json")
+
+	// Build the request
+	req, err := http.NewRequest("GET", "https://connect.deezer.com/oauth/access_token.php?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = provider.CheckStatus(provider.Deezer, http.StatusOK, resp.StatusCode, body)
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Bytes("body", body).Msg("Authorization code Deezer")
+		return err
+	}
+
+	// A wrong code is answered with a 200 and a plain text message
+	var authorization struct {
+		AccessToken string `json:"access_token"`
+	}
+	if json.Unmarshal(body, &authorization) != nil || authorization.AccessToken == "" {
+		log.Error().Int("status_code", resp.StatusCode).Bytes("body", body).Msg("Authorization code Deezer")
+		return fmt.Errorf("%s: %s: %w", provider.Deezer, body, provider.ErrUnauthorized)
+	}
+
+	viper.Set("deezer.access_token", authorization.AccessToken)
+	err = viper.WriteConfig()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("Deezer access_token has been successfully updated!")
+
+	return nil
+}
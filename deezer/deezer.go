@@ -8,7 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"newmusicrelease/album"
-	"time"
+	"newmusicrelease/provider"
 
 	"github.com/rs/zerolog/log"
 )
@@ -19,6 +19,12 @@ type AlbumSearchResponse struct {
 }
 
 func SearchAlbum(album *album.Album) error {
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, func() error {
+		return searchAlbum(album)
+	})
+}
+
+func searchAlbum(album *album.Album) error {
 	log.Info().Str("platform", "Deezer").Msgf("Searching %s from %s", album.AlbumName, album.ArtistName)
 
 	baseURL := "https://api.deezer.com/search/album"
@@ -47,18 +53,13 @@ func SearchAlbum(album *album.Album) error {
 		return err
 	}
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		sleepingTime, err := time.ParseDuration("3s")
-		if err != nil {
-			return err
-		}
-		time.Sleep(sleepingTime)
-		return SearchAlbum(album)
+	err = provider.CheckStatus(provider.Deezer, http.StatusOK, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) {
+		return err
 	}
-
-	if resp.StatusCode != http.StatusOK {
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("")
-		return fmt.Errorf("deezer: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		return err
 	}
 
 	// Parse the JSON response
@@ -71,7 +72,7 @@ func SearchAlbum(album *album.Album) error {
 
 	// Extract the ID from the response and add it to the album
 	if searchResponse.Total == 0 {
-		return errors.New("no album match")
+		return provider.ErrNotFound
 	}
 
 	// TODO:	Make sure the first album is a match, sometimes it returns only a Single.
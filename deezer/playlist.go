@@ -0,0 +1,266 @@
+package deezer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"newmusicrelease/provider"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+type Track struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	ISRC  string `json:"isrc"`
+	Link  string `json:"link"`
+}
+
+type User struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Playlist struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	Creator User   `json:"creator"`
+}
+
+// Error is what Deezer answers with, along a 200 status code, when a request fails.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// Error codes documented at https://developers.deezer.com/api/errors
+const (
+	errorQuota          = 4
+	errorOAuthException = 300
+	errorDataNotFound   = 800
+)
+
+// playlistBatchSize is how many tracks are added to or removed from a playlist per request.
+const playlistBatchSize = 50
+
+// checkError maps the error object of a response to the provider errors.
+func checkError(body []byte) error {
+	var response struct {
+		Error *Error `json:"error"`
+	}
+	// Some endpoints answer with a bare true or id
+	if json.Unmarshal(body, &response) != nil || response.Error == nil {
+		return nil
+	}
+
+	switch response.Error.Code {
+	case errorQuota:
+		return fmt.Errorf("%s: %s: %w", provider.Deezer, response.Error.Message, provider.ErrRateLimited)
+	case errorOAuthException:
+		return fmt.Errorf("%s: %s: %w", provider.Deezer, response.Error.Message, provider.ErrUnauthorized)
+	case errorDataNotFound:
+		return fmt.Errorf("%s: %s: %w", provider.Deezer, response.Error.Message, provider.ErrNotFound)
+	}
+	return fmt.Errorf("%s: %s (%d): %w", provider.Deezer, response.Error.Message, response.Error.Code, provider.ErrUpstream)
+}
+
+// call sends a request authenticated with the user's access token and decodes the
+// response into v, unless v is nil.
+func call(method string, path string, query url.Values, v any) error {
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, func() error {
+		return send(method, path, query, v)
+	})
+}
+
+func send(method string, path string, query url.Values, v any) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_token", viper.GetString("deezer.access_token"))
+	apiURL := "https://api.deezer.com" + path + "?" + query.Encode()
+
+	log.Debug().Str("method", method).Str("path", path).Msg("")
+
+	req, err := http.NewRequest(method, apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = provider.CheckStatus(provider.Deezer, http.StatusOK, resp.StatusCode, body)
+	if err == nil {
+		err = checkError(body)
+	}
+	if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Str("path", path).Bytes("body", body).Msg("")
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// TrackByISRC looks up the track with the given ISRC. It returns ErrNotFound when Deezer
+// doesn't have it.
+func TrackByISRC(isrc string) (Track, error) {
+	var track Track
+	err := call("GET", "/track/isrc:"+url.PathEscape(isrc), nil, &track)
+	return track, err
+}
+
+// SyncPlaylist creates the playlist called name in the user's library, or finds it when a
+// previous run already created it, and makes its tracks the ones given. A playlist that
+// already has them is left untouched.
+func SyncPlaylist(name string, description string, public bool, trackIDs []int) (provider.Playlist, error) {
+	log.Info().Str("platform", "Deezer").Int("nb_tracks", len(trackIDs)).Msgf("Syncing the playlist %s", name)
+
+	var user User
+	err := call("GET", "/user/me", nil, &user)
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	playlist, err := findPlaylist(user.ID, name)
+	if errors.Is(err, provider.ErrNotFound) {
+		playlist, err = createPlaylist(name, description, public)
+	}
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	current, err := playlistTracks(playlist.ID)
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	// Tracks can only be appended, start over when anything differs
+	if !slices.Equal(current, trackIDs) {
+		err = editTracks("DELETE", playlist.ID, current)
+		if err != nil {
+			return provider.Playlist{}, err
+		}
+		err = editTracks("POST", playlist.ID, trackIDs)
+		if err != nil {
+			return provider.Playlist{}, err
+		}
+	}
+
+	return provider.Playlist{
+		Platform: provider.Deezer,
+		ID:       strconv.Itoa(playlist.ID),
+		Name:     name,
+		URL:      fmt.Sprintf("https://www.deezer.com/playlist/%d", playlist.ID),
+	}, nil
+}
+
+func findPlaylist(userID int, name string) (Playlist, error) {
+	for index := 0; ; {
+		var page struct {
+			Data []Playlist `json:"data"`
+			Next string     `json:"next"`
+		}
+		err := call("GET", "/user/me/playlists", url.Values{"index": {strconv.Itoa(index)}, "limit": {"100"}}, &page)
+		if err != nil {
+			return Playlist{}, err
+		}
+		for _, playlist := range page.Data {
+			if playlist.Title == name && playlist.Creator.ID == userID {
+				return playlist, nil
+			}
+		}
+		if page.Next == "" || len(page.Data) == 0 {
+			break
+		}
+		index += len(page.Data)
+	}
+	return Playlist{}, fmt.Errorf("%s: playlist %q: %w", provider.Deezer, name, provider.ErrNotFound)
+}
+
+func createPlaylist(name string, description string, public bool) (Playlist, error) {
+	var created struct {
+		ID int `json:"id"`
+	}
+	err := call("POST", "/user/me/playlists", url.Values{"title": {name}}, &created)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	query := url.Values{
+		"description": {description},
+		"public":      {strconv.FormatBool(public)},
+	}
+	err = call("POST", fmt.Sprintf("/playlist/%d", created.ID), query, nil)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	log.Info().Str("platform", "Deezer").Int("playlist_id", created.ID).Msgf("Created the playlist %s", name)
+	return Playlist{ID: created.ID, Title: name}, nil
+}
+
+// playlistTracks returns the ids of the tracks of the playlist, in order.
+func playlistTracks(playlistID int) ([]int, error) {
+	var ids []int
+	for {
+		var page struct {
+			Data []Track `json:"data"`
+			Next string  `json:"next"`
+		}
+		query := url.Values{"index": {strconv.Itoa(len(ids))}, "limit": {"100"}}
+		err := call("GET", fmt.Sprintf("/playlist/%d/tracks", playlistID), query, &page)
+		if err != nil {
+			return ids, err
+		}
+		for _, track := range page.Data {
+			ids = append(ids, track.ID)
+		}
+		if page.Next == "" || len(page.Data) == 0 {
+			return ids, nil
+		}
+	}
+}
+
+// editTracks adds (POST) or removes (DELETE) tracks of the playlist.
+func editTracks(method string, playlistID int, trackIDs []int) error {
+	for k := 0; k < len(trackIDs); k += playlistBatchSize {
+		end := min(k+playlistBatchSize, len(trackIDs))
+
+		songs := make([]string, 0, end-k)
+		for _, id := range trackIDs[k:end] {
+			songs = append(songs, strconv.Itoa(id))
+		}
+
+		err := call(method, fmt.Sprintf("/playlist/%d/tracks", playlistID), url.Values{"songs": {strings.Join(songs, ",")}}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package export writes the albums of a week in formats other tools can load: a JSON
+// document with a versioned schema, and a CSV with one album per row.
+//
+// The schema only changes in a backward compatible way within a version: fields may be
+// added, never renamed, removed or given another type. Anything else bumps SchemaVersion.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"newmusicrelease/album"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the Document schema.
+const SchemaVersion = 1
+
+type Document struct {
+	SchemaVersion int `json:"schema_version"`
+	// Week is the Friday the albums came out, formatted as 2006-01-02.
+	Week        string    `json:"week"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Albums      []Album   `json:"albums"`
+}
+
+type Album struct {
+	// Rank starts at 1 for the album with the highest score.
+	Rank    int      `json:"rank"`
+	Artist  string   `json:"artist"`
+	Artists []string `json:"artists"`
+	Album   string   `json:"album"`
+	Genres  []string `json:"genres"`
+	// ReleaseDate is 2006-01-02, or less precise when the platform only knows the year.
+	ReleaseDate string `json:"release_date"`
+	Type        string `json:"type"`
+	Label       string `json:"label"`
+	UPC         string `json:"upc"`
+	// Popularity is the Spotify popularity, from 0 to 100.
+	Popularity int     `json:"popularity"`
+	Score      float64 `json:"score"`
+	URLs       URLs    `json:"urls"`
+}
+
+type URLs struct {
+	Spotify string `json:"spotify"`
+	Tidal   string `json:"tidal"`
+	Deezer  string `json:"deezer"`
+}
+
+// New builds the document of the week from the albums, which are expected in rank order.
+func New(week time.Time, albums []album.Album) (Document, error) {
+	doc := Document{
+		SchemaVersion: SchemaVersion,
+		Week:          week.Format("2006-01-02"),
+		GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+		Albums:        make([]Album, 0, len(albums)),
+	}
+
+	for i, a := range albums {
+		spotifyURL, err := a.GetSpotifyURL()
+		if err != nil {
+			return doc, err
+		}
+		tidalURL, err := a.GetTidalURL()
+		if err != nil {
+			return doc, err
+		}
+		deezerURL, err := a.GetDeezerURL()
+		if err != nil {
+			return doc, err
+		}
+
+		artists := make([]string, 0, len(a.Spotify.Artists))
+		for _, artist := range a.Spotify.Artists {
+			artists = append(artists, artist.Name)
+		}
+		if len(artists) == 0 {
+			artists = append(artists, a.ArtistName)
+		}
+
+		doc.Albums = append(doc.Albums, Album{
+			Rank:        i + 1,
+			Artist:      a.ArtistName,
+			Artists:     artists,
+			Album:       a.AlbumName,
+			Genres:      append([]string{}, a.Genres...),
+			ReleaseDate: a.ReleaseDate(),
+			Type:        releaseType(a),
+			Label:       a.Spotify.Label,
+			UPC:         a.Spotify.UPC,
+			Popularity:  a.Spotify.Popularity,
+			Score:       a.Score,
+			URLs:        URLs{Spotify: spotifyURL, Tidal: tidalURL, Deezer: deezerURL},
+		})
+	}
+	return doc, nil
+}
+
+// releaseType is album or single, as told by the platforms.
+func releaseType(a album.Album) string {
+	if a.IsSingle() {
+		return "single"
+	}
+	return "album"
+}
+
+// WriteJSON writes the document indented, for diffs between weeks to stay readable.
+func WriteJSON(w io.Writer, doc Document) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// CSVHeader are the columns of the CSV export, in order. Columns are only ever added at
+// the end.
+var CSVHeader = []string{
+	"rank", "artist", "album", "genres", "release_date", "popularity", "score",
+	"spotify_url", "tidal_url", "deezer_url", "type", "label",
+}
+
+// WriteCSV writes one row per album after the header. Genres are separated by "; " so
+// the column can be split again.
+func WriteCSV(w io.Writer, doc Document) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(CSVHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range doc.Albums {
+		err = writer.Write([]string{
+			strconv.Itoa(a.Rank),
+			strings.Join(a.Artists, ", "),
+			a.Album,
+			strings.Join(a.Genres, "; "),
+			a.ReleaseDate,
+			strconv.Itoa(a.Popularity),
+			strconv.FormatFloat(a.Score, 'f', -1, 64),
+			a.URLs.Spotify,
+			a.URLs.Tidal,
+			a.URLs.Deezer,
+			a.Type,
+			a.Label,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
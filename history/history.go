@@ -0,0 +1,201 @@
+// Package history keeps the newsletter of every week, one JSON file per week, so past
+// issues can be published again without calling the platforms.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"newmusicrelease/album"
+	"newmusicrelease/provider"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// dateLayout names the file of a week after its Friday.
+const dateLayout = "2006-01-02"
+
+// Week is what was sent for one Friday.
+type Week struct {
+	Date time.Time
+	// Albums are the albums of the newsletter, in the order they were rendered, followed by
+	// the ones only some subscribers got.
+	Albums    []album.Album
+	Playlists []provider.Playlist
+	// Reactions are what the subscribers told of the albums, the latest per subscriber
+	// and album.
+	Reactions []Reaction
+	// Clicks are the links of the newsletter opened through the tracker.
+	Clicks []Click
+}
+
+// Kinds of reactions to an album.
+const (
+	Like    = "like"
+	Dislike = "dislike"
+	Heard   = "heard"
+)
+
+// Reaction is what a subscriber told of an album of the week.
+type Reaction struct {
+	// Subscriber is the ID of the subscriber, see subscriber.Subscriber.ID.
+	Subscriber string
+	// Album is the key of the album, see album.Album.Key.
+	Album string
+	// Kind is like, dislike or heard.
+	Kind string
+	At   time.Time
+}
+
+// Click is a link of the newsletter opened by a subscriber.
+type Click struct {
+	Subscriber string
+	Album      string
+	Platform   string
+	At         time.Time
+}
+
+// ID is the Friday of the week formatted as 2006-01-02, also the name of its file.
+func (w Week) ID() string {
+	return w.Date.Format(dateLayout)
+}
+
+type Store struct {
+	Dir string
+	// mu keeps reactions recorded at the same time from overwriting each other, and lock
+	// does the same for the other processes.
+	mu sync.Mutex
+}
+
+// FromConfig returns the store in history.dir.
+func FromConfig() *Store {
+	viper.SetDefault("history.dir", "history")
+	return &Store{Dir: viper.GetString("history.dir")}
+}
+
+// Save writes the week, replacing what a previous run saved for the same Friday. The
+// reactions and clicks already recorded for the week are kept.
+func (s *Store) Save(w Week) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	previous, err := s.Load(w.ID())
+	if err == nil && len(w.Reactions) == 0 && len(w.Clicks) == 0 {
+		w.Reactions = previous.Reactions
+		w.Clicks = previous.Clicks
+	}
+	return s.write(w)
+}
+
+// React records r in the week with the given id, replacing the previous reaction of the
+// subscriber to the same album.
+func (s *Store) React(id string, r Reaction) error {
+	return s.update(id, func(w *Week) {
+		w.Reactions = slices.DeleteFunc(w.Reactions, func(previous Reaction) bool {
+			return previous.Subscriber == r.Subscriber && previous.Album == r.Album
+		})
+		w.Reactions = append(w.Reactions, r)
+	})
+}
+
+// Click records c in the week with the given id.
+func (s *Store) Click(id string, c Click) error {
+	return s.update(id, func(w *Week) {
+		w.Clicks = append(w.Clicks, c)
+	})
+}
+
+// update loads the week with the given id, changes it with fn and saves it, one update
+// at a time.
+func (s *Store) update(id string, fn func(w *Week)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	w, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	fn(&w)
+	return s.write(w)
+}
+
+func (s *Store) write(w Week) error {
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write next to the file and rename so a reader never sees half a week
+	path := filepath.Join(s.Dir, w.ID()+".json")
+	err = os.WriteFile(path+".tmp", data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Load reads the week of the Friday with the given id. It returns an error matching
+// fs.ErrNotExist when nothing was saved for it.
+func (s *Store) Load(id string) (Week, error) {
+	var w Week
+	data, err := os.ReadFile(filepath.Join(s.Dir, id+".json"))
+	if err != nil {
+		return w, err
+	}
+	err = json.Unmarshal(data, &w)
+	return w, err
+}
+
+// Weeks returns every saved week, the most recent first.
+func (s *Store) Weeks() ([]Week, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var weeks []Week
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, id); err != nil {
+			continue
+		}
+		w, err := s.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		weeks = append(weeks, w)
+	}
+
+	sort.Slice(weeks, func(i, j int) bool {
+		return weeks[i].Date.After(weeks[j].Date)
+	})
+	return weeks, nil
+}
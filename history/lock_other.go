@@ -0,0 +1,9 @@
+//go:build !unix
+
+package history
+
+// lock only serializes the writes of this process where file locks aren't available, the
+// processes writing to the same store have to take turns.
+func (s *Store) lock() (func(), error) {
+	return func() {}, nil
+}
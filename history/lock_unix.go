@@ -0,0 +1,32 @@
+//go:build unix
+
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lock takes an exclusive lock on the directory of the store, shared with the other
+// processes writing to it, such as serve and telegram running next to a run. It waits
+// for the lock to be free and returns the function releasing it.
+func (s *Store) lock() (func(), error) {
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
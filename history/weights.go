@@ -0,0 +1,110 @@
+package history
+
+import (
+	"math"
+	"newmusicrelease/album"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How much each signal counts towards the genres and the artists of the album it is about.
+// A click says less than a reaction, and having already heard an album only says the
+// artist is known.
+var signals = map[string]struct{ genre, artist float64 }{
+	Like:    {1, 1},
+	Dislike: {-1, -1},
+	Heard:   {0, 0.5},
+	click:   {0.25, 0.25},
+}
+
+// click is the signal of the clicks, next to the kinds of reactions.
+const click = "click"
+
+// Weights are how much the subscribers favor a genre or an artist, learned from their
+// reactions and clicks. Positive is more like this, negative less.
+type Weights struct {
+	Genres  map[string]float64
+	Artists map[string]float64
+}
+
+// Learn sums the signals of the weeks. A signal counts for half as much every halfLife
+// weeks, so recent tastes win. Clicks count once per subscriber and album.
+func Learn(weeks []Week, now time.Time, halfLife float64) Weights {
+	weights := Weights{Genres: make(map[string]float64), Artists: make(map[string]float64)}
+
+	for _, w := range weeks {
+		albums := make(map[string]album.Album)
+		for _, a := range w.Albums {
+			albums[a.Key()] = a
+		}
+
+		decay := 1.0
+		if halfLife > 0 {
+			age := now.Sub(w.Date).Hours() / 24 / 7
+			decay = math.Pow(0.5, max(age, 0)/halfLife)
+		}
+
+		add := func(kind string, key string) {
+			a, ok := albums[key]
+			if !ok || key == "" {
+				return
+			}
+			signal := signals[kind]
+			for _, genre := range a.Genres {
+				weights.Genres[genre] += signal.genre * decay
+			}
+			for _, artist := range artistKeys(a) {
+				weights.Artists[artist] += signal.artist * decay
+			}
+		}
+
+		for _, r := range w.Reactions {
+			add(r.Kind, r.Album)
+		}
+		clicked := make(map[[2]string]bool)
+		for _, c := range w.Clicks {
+			if !clicked[[2]string{c.Subscriber, c.Album}] {
+				clicked[[2]string{c.Subscriber, c.Album}] = true
+				add(click, c.Album)
+			}
+		}
+	}
+	return weights
+}
+
+// Apply adds to the score of every album the weights of its genres and artists times
+// strength, and sorts the albums by score again.
+func (w Weights) Apply(albums []album.Album, strength float64) []album.Album {
+	for i, a := range albums {
+		var boost float64
+		for _, genre := range a.Genres {
+			boost += w.Genres[genre]
+		}
+		if len(a.Genres) > 0 {
+			boost /= float64(len(a.Genres))
+		}
+		for _, artist := range artistKeys(a) {
+			boost += w.Artists[artist]
+		}
+		albums[i].Score += strength * boost
+	}
+
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[j].Score < albums[i].Score
+	})
+	return albums
+}
+
+// artistKeys identifies the artists of the album with their Spotify IDs, or with its
+// artist name when Spotify doesn't know them.
+func artistKeys(a album.Album) []string {
+	var keys []string
+	for _, artist := range a.Spotify.Artists {
+		keys = append(keys, "spotify:"+artist.Id)
+	}
+	if len(keys) == 0 && a.ArtistName != "" {
+		keys = append(keys, strings.ToLower(a.ArtistName))
+	}
+	return keys
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"errors"
+	"net/smtp"
+)
+
+// loginAuth implements the LOGIN mechanism, still the only one offered by some providers
+// such as Office 365. net/smtp only ships PLAIN and CRAM-MD5.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, refuse to send the password in clear text to a remote server
+	if !server.TLS && a.host != "localhost" && a.host != "127.0.0.1" && a.host != "::1" {
+		return "", nil, errors.New("smtp: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("smtp: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "username:":
+		return []byte(a.username), nil
+	case "Password:", "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.New("smtp: unexpected LOGIN challenge " + string(fromServer))
+}
@@ -0,0 +1,274 @@
+// Package mailer sends the newsletter over SMTP, reusing connections across recipients.
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+type TLSMode string
+
+const (
+	// TLSNone sends everything in clear text, only meant for a local relay.
+	TLSNone TLSMode = "none"
+	// TLSStartTLS connects in clear text then upgrades the connection, usually on port 587.
+	TLSStartTLS TLSMode = "starttls"
+	// TLSImplicit connects with TLS right away, usually on port 465.
+	TLSImplicit TLSMode = "tls"
+)
+
+type AuthMode string
+
+const (
+	// AuthNone skips the authentication, only meant for a local relay.
+	AuthNone AuthMode = "none"
+	// AuthPlain sends the username and password as they are, over TLS only.
+	AuthPlain AuthMode = "plain"
+	// AuthLogin is the LOGIN mechanism, see loginAuth.
+	AuthLogin AuthMode = "login"
+	// AuthCRAMMD5 proves the password without sending it.
+	AuthCRAMMD5 AuthMode = "cram-md5"
+)
+
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      TLSMode
+	// Auth is the authentication mechanism, no authentication when empty.
+	Auth     AuthMode
+	Timeout  time.Duration
+	PoolSize int
+}
+
+// FromConfig reads the SMTP settings from the main config file.
+func FromConfig() Config {
+	viper.SetDefault("smtp_tls", string(TLSStartTLS))
+	viper.SetDefault("smtp_auth", string(AuthPlain))
+	viper.SetDefault("smtp_timeout", "30s")
+	viper.SetDefault("smtp_pool_size", 1)
+
+	return Config{
+		Host:     viper.GetString("smtp_host"),
+		Port:     viper.GetInt("smtp_port"),
+		Username: viper.GetString("email"),
+		Password: viper.GetString("password"),
+		TLS:      TLSMode(viper.GetString("smtp_tls")),
+		Auth:     AuthMode(strings.ToLower(viper.GetString("smtp_auth"))),
+		Timeout:  viper.GetDuration("smtp_timeout"),
+		PoolSize: viper.GetInt("smtp_pool_size"),
+	}
+}
+
+type conn struct {
+	nc     net.Conn
+	client *smtp.Client
+}
+
+// Transport keeps up to PoolSize authenticated connections open so that sending to many
+// subscribers doesn't go through the TLS handshake and authentication every time.
+type Transport struct {
+	cfg   Config
+	conns chan *conn
+}
+
+func New(cfg Config) (*Transport, error) {
+	switch cfg.TLS {
+	case TLSNone, TLSStartTLS, TLSImplicit:
+	default:
+		return nil, fmt.Errorf("smtp: unknown tls mode %q", cfg.TLS)
+	}
+	switch cfg.Auth {
+	case AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5, "":
+	default:
+		return nil, fmt.Errorf("smtp: unknown auth mechanism %q", cfg.Auth)
+	}
+	if cfg.PoolSize < 1 {
+		cfg.PoolSize = 1
+	}
+	return &Transport{cfg: cfg, conns: make(chan *conn, cfg.PoolSize)}, nil
+}
+
+func (cfg Config) auth() smtp.Auth {
+	switch cfg.Auth {
+	case AuthPlain:
+		return smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	case AuthLogin:
+		return &loginAuth{username: cfg.Username, password: cfg.Password, host: cfg.Host}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(cfg.Username, cfg.Password)
+	}
+	return nil
+}
+
+func (t *Transport) dial() (*conn, error) {
+	addr := net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: t.cfg.Host}
+	dialer := &net.Dialer{Timeout: t.cfg.Timeout}
+
+	var nc net.Conn
+	var err error
+	if t.cfg.TLS == TLSImplicit {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.setDeadline(nc)
+
+	client, err := smtp.NewClient(nc, t.cfg.Host)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	if t.cfg.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("smtp: server doesn't support STARTTLS")
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	auth := t.cfg.auth()
+	if auth != nil {
+		err = client.Auth(auth)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	log.Debug().Str("addr", addr).Str("tls", string(t.cfg.TLS)).Msg("SMTP connection opened")
+	return &conn{nc: nc, client: client}, nil
+}
+
+func (t *Transport) setDeadline(nc net.Conn) {
+	if t.cfg.Timeout > 0 {
+		nc.SetDeadline(time.Now().Add(t.cfg.Timeout))
+	}
+}
+
+func (t *Transport) get() (*conn, error) {
+	select {
+	case c := <-t.conns:
+		t.setDeadline(c.nc)
+		// The server may have dropped an idle connection in the meantime
+		if c.client.Noop() == nil {
+			return c, nil
+		}
+		c.client.Close()
+	default:
+	}
+	return t.dial()
+}
+
+func (t *Transport) put(c *conn) {
+	select {
+	case t.conns <- c:
+	default:
+		c.client.Quit()
+	}
+}
+
+// Send delivers e to every recipient in To, Cc and Bcc. A connection that failed is
+// discarded instead of being reused for the next message.
+func (t *Transport) Send(e *email.Email) error {
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return err
+	}
+
+	var recipients []string
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, r := range list {
+			address, err := mail.ParseAddress(r)
+			if err != nil {
+				return err
+			}
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return errors.New("smtp: no recipient")
+	}
+
+	msg, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	c, err := t.get()
+	if err != nil {
+		return err
+	}
+
+	err = send(c.client, from.Address, recipients, msg)
+	if err != nil {
+		c.client.Close()
+		return err
+	}
+
+	// The message is already accepted at this point, a failed reset only means the
+	// connection can't be reused
+	err = c.client.Reset()
+	if err != nil {
+		c.client.Close()
+		return nil
+	}
+	t.put(c)
+	return nil
+}
+
+func send(client *smtp.Client, from string, recipients []string, msg []byte) error {
+	err := client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		err = client.Rcpt(r)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Close says goodbye to the server on every idle connection.
+func (t *Transport) Close() error {
+	var errs []error
+	for {
+		select {
+		case c := <-t.conns:
+			errs = append(errs, c.client.Quit())
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+// Breaker stops calling a platform after Threshold consecutive failures. Once Cooldown
+// has elapsed a single probe call is let through: if it succeeds the platform is enabled
+// again, otherwise the breaker stays open for another Cooldown.
+// A zero Cooldown keeps the platform disabled for the rest of the run.
+type Breaker struct {
+	Platform  string
+	Threshold int
+	Cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	tripped  bool
+}
+
+func NewBreaker(platform string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Platform:  platform,
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Do calls fn unless the breaker is open, in which case ErrCircuitOpen is returned.
+func (b *Breaker) Do(fn func() error) error {
+	if b.open() {
+		return fmt.Errorf("%s: %w", b.Platform, ErrCircuitOpen)
+	}
+	err := fn()
+	if isFailure(err) {
+		b.failure(err)
+	} else {
+		b.success()
+	}
+	return err
+}
+
+// Trip opens the breaker right away, e.g. when authenticating with the platform failed.
+func (b *Breaker) Trip(err error) {
+	b.failures = b.Threshold
+	b.failure(err)
+}
+
+// Degraded reports whether the breaker has tripped at least once during the run.
+func (b *Breaker) Degraded() bool {
+	return b.tripped
+}
+
+func (b *Breaker) open() bool {
+	if b.openedAt.IsZero() {
+		return false
+	}
+	if b.Cooldown > 0 && time.Since(b.openedAt) >= b.Cooldown {
+		log.Info().Str("platform", b.Platform).Msg("Circuit half-open, probing")
+		return false
+	}
+	return true
+}
+
+func (b *Breaker) success() {
+	if !b.openedAt.IsZero() {
+		log.Info().Str("platform", b.Platform).Msg("Circuit closed")
+	}
+	b.failures = 0
+	b.openedAt = time.Time{}
+}
+
+func (b *Breaker) failure(err error) {
+	b.failures++
+	if b.failures >= b.Threshold || !b.openedAt.IsZero() {
+		if b.openedAt.IsZero() {
+			log.Warn().Err(err).Str("platform", b.Platform).Int("failures", b.failures).Msg("Circuit open, skipping platform")
+		}
+		b.openedAt = time.Now()
+		b.tripped = true
+	}
+}
+
+// isFailure tells apart errors meaning the platform is unhealthy from the ones that
+// are expected during a normal run, such as an album missing from the catalog.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrUnavailableForLegalReasons)
+}
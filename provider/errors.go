@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrNotFound                   = errors.New("no album match")
+	ErrRateLimited                = errors.New("rate limited")
+	ErrUnauthorized               = errors.New("unauthorized")
+	ErrUnavailableForLegalReasons = errors.New("unavailable for legal reasons")
+	ErrUpstream                   = errors.New("upstream error")
+)
+
+// UpstreamError is returned when a platform answers with a status code we don't
+// know how to handle. It matches ErrUpstream with errors.Is.
+type UpstreamError struct {
+	Platform   string
+	Expected   int
+	StatusCode int
+	Body       []byte
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s: expected %d, got %d", e.Platform, e.Expected, e.StatusCode)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return ErrUpstream
+}
+
+// CheckStatus maps the status code of a platform response to one of the errors above.
+// It returns nil when the status code is the expected one.
+func CheckStatus(platform string, expected int, statusCode int, body []byte) error {
+	switch statusCode {
+	case expected:
+		return nil
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%s: %w", platform, ErrRateLimited)
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%s: %w", platform, ErrUnauthorized)
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: %w", platform, ErrNotFound)
+	case http.StatusUnavailableForLegalReasons:
+		return fmt.Errorf("%s: %w", platform, ErrUnavailableForLegalReasons)
+	}
+	return &UpstreamError{
+		Platform:   platform,
+		Expected:   expected,
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+// RetryRateLimited calls fn again while it fails with ErrRateLimited, sleeping between
+// attempts. After maxAttempts the last error is returned so the caller can decide what to do.
+func RetryRateLimited(maxAttempts int, wait time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = fn()
+		if !errors.Is(err, ErrRateLimited) {
+			return err
+		}
+		time.Sleep(wait)
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+package provider
+
+// Playlist is the playlist of the week created on one of the platforms.
+type Playlist struct {
+	Platform string
+	ID       string
+	Name     string
+	URL      string
+}
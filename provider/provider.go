@@ -0,0 +1,17 @@
+// Package provider holds what the streaming platform clients (spotify, tidal, deezer) have in common.
+package provider
+
+import "time"
+
+const (
+	Spotify = "Spotify"
+	Tidal   = "Tidal"
+	Deezer  = "Deezer"
+)
+
+const (
+	// RateLimitAttempts is how many times a request is sent before giving up on a 429.
+	RateLimitAttempts = 5
+	// RateLimitWait is how long we sleep after a 429 before sending the request again.
+	RateLimitWait = 3 * time.Second
+)
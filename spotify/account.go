@@ -0,0 +1,50 @@
+package spotify
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Account holds the Spotify credentials of one listener. The tokens are read from the
+// "spotify" section of a config file and written back to it when they are refreshed.
+type Account struct {
+	Name string
+	v    *viper.Viper
+}
+
+// Default is the account configured in the main config file. It is used for the
+// album lookups shared by every profile.
+func Default() *Account {
+	return &Account{Name: "default", v: viper.GetViper()}
+}
+
+// LoadAccount reads the credentials of a profile stored in its own file. The client_id
+// and client_secret fall back to the ones of the main config since they belong to the app.
+func LoadAccount(name string, path string) (*Account, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{Name: name, v: v}, nil
+}
+
+func (a *Account) accessToken() string {
+	return a.v.GetString("spotify.access_token")
+}
+
+func (a *Account) clientID() string {
+	if id := a.v.GetString("spotify.client_id"); id != "" {
+		return id
+	}
+	return viper.GetString("spotify.client_id")
+}
+
+func (a *Account) clientSecret() string {
+	if secret := a.v.GetString("spotify.client_secret"); secret != "" {
+		return secret
+	}
+	return viper.GetString("spotify.client_secret")
+}
@@ -0,0 +1,236 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"newmusicrelease/provider"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type User struct {
+	ID           string       `json:"id"`
+	DisplayName  string       `json:"display_name"`
+	ExternalURLs ExternalURLs `json:"external_urls"`
+}
+
+type PlaylistObject struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Public       bool         `json:"public"`
+	Owner        User         `json:"owner"`
+	ExternalURLs ExternalURLs `json:"external_urls"`
+	SnapshotID   string       `json:"snapshot_id"`
+	URI          string       `json:"uri"`
+}
+
+type Playlists struct {
+	Href     string           `json:"href"`
+	Limit    int              `json:"limit"`
+	Next     string           `json:"next"`
+	Offset   int              `json:"offset"`
+	Previous string           `json:"previous"`
+	Total    int              `json:"total"`
+	Items    []PlaylistObject `json:"items"`
+}
+
+// playlistBatchSize is how many items /v1/playlists/{id}/tracks accepts per request.
+const playlistBatchSize = 100
+
+// GetTracks fetches the full track objects, which unlike the ones of an album have a
+// popularity and an ISRC, batching the ids by 50 as allowed by /v1/tracks.
+func (a *Account) GetTracks(ids []string) ([]Track, error) {
+	batchSize := 50
+	var tracks []Track
+
+	for k := 0; k < len(ids); k += batchSize {
+		end := k + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		baseURL := "https://api.spotify.com/v1/tracks?ids=" + strings.Join(ids[k:end], ",")
+
+		log.Debug().Str("base_url", baseURL).Strs("tracks_ids", ids[k:end]).Msg("spotify.GetTracks")
+
+		var trackResponses struct {
+			Tracks []Track `json:"tracks"`
+		}
+		err := a.retry(func() error {
+			return a.getPage(baseURL, &trackResponses)
+		})
+		if err != nil {
+			return tracks, err
+		}
+		tracks = append(tracks, trackResponses.Tracks...)
+	}
+	return tracks, nil
+}
+
+// GetCurrentUser returns the user the account belongs to.
+func (a *Account) GetCurrentUser() (User, error) {
+	var user User
+	err := a.retry(func() error {
+		return a.getPage("https://api.spotify.com/v1/me", &user)
+	})
+	return user, err
+}
+
+// SyncPlaylist creates the playlist called name in the user's library, or finds it when a
+// previous run already created it, and replaces its tracks with uris. Running it again with
+// the same tracks leaves the playlist as it was.
+//
+// The account needs the playlist-read-private and playlist-modify-public or
+// playlist-modify-private scopes.
+func (a *Account) SyncPlaylist(name string, description string, public bool, uris []string) (provider.Playlist, error) {
+	log.Info().Str("platform", "Spotify").Str("account", a.Name).Int("nb_tracks", len(uris)).Msgf("Syncing the playlist %s", name)
+
+	user, err := a.GetCurrentUser()
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	playlist, err := a.findPlaylist(user.ID, name)
+	if errors.Is(err, provider.ErrNotFound) {
+		playlist, err = a.createPlaylist(user.ID, name, description, public)
+	}
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	err = a.replaceTracks(playlist.ID, uris)
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	return provider.Playlist{
+		Platform: provider.Spotify,
+		ID:       playlist.ID,
+		Name:     playlist.Name,
+		URL:      playlist.ExternalURLs.Spotify,
+	}, nil
+}
+
+// findPlaylist looks for a playlist called name owned by the user among the playlists of
+// their library.
+func (a *Account) findPlaylist(userID string, name string) (PlaylistObject, error) {
+	pageURL := "https://api.spotify.com/v1/me/playlists?limit=50"
+
+	for pageURL != "" {
+		var page Playlists
+		err := a.retry(func() error {
+			return a.getPage(pageURL, &page)
+		})
+		if err != nil {
+			return PlaylistObject{}, err
+		}
+		for _, playlist := range page.Items {
+			if playlist.Name == name && playlist.Owner.ID == userID {
+				return playlist, nil
+			}
+		}
+		pageURL = page.Next
+	}
+	return PlaylistObject{}, fmt.Errorf("%s: playlist %q: %w", provider.Spotify, name, provider.ErrNotFound)
+}
+
+func (a *Account) createPlaylist(userID string, name string, description string, public bool) (PlaylistObject, error) {
+	payload := map[string]any{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	}
+
+	var playlist PlaylistObject
+	err := a.retry(func() error {
+		return a.send("POST", "https://api.spotify.com/v1/users/"+userID+"/playlists", payload, http.StatusCreated, &playlist)
+	})
+	if err != nil {
+		return playlist, err
+	}
+
+	log.Info().Str("platform", "Spotify").Str("playlist_id", playlist.ID).Msgf("Created the playlist %s", name)
+	return playlist, nil
+}
+
+// replaceTracks sets the items of the playlist to uris. The first batch replaces what was
+// there, the others are appended.
+func (a *Account) replaceTracks(playlistID string, uris []string) error {
+	baseURL := "https://api.spotify.com/v1/playlists/" + playlistID + "/tracks"
+
+	end := min(len(uris), playlistBatchSize)
+	// An empty list, not null, empties the playlist
+	first := append([]string{}, uris[:end]...)
+	err := a.retry(func() error {
+		return a.send("PUT", baseURL, map[string]any{"uris": first}, http.StatusOK, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	for k := end; k < len(uris); k += playlistBatchSize {
+		end := min(k+playlistBatchSize, len(uris))
+		err := a.retry(func() error {
+			return a.send("POST", baseURL, map[string]any{"uris": uris[k:end]}, http.StatusCreated, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends payload as JSON to the Web API and decodes the response into v, unless v is nil.
+func (a *Account) send(method string, pageURL string, payload any, expected int, v any) error {
+	accessToken := a.accessToken()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str("method", method).Str("base_url", pageURL).Msg("")
+
+	req, err := http.NewRequest(method, pageURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// Set the necessary headers
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = provider.CheckStatus(provider.Spotify, expected, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrUnauthorized) {
+		return err
+	}
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("")
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
@@ -9,12 +9,11 @@ import (
 	"net/http"
 	"net/url"
 	"newmusicrelease/album"
+	"newmusicrelease/provider"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/viper"
 )
 
 type Authorization struct {
@@ -86,13 +85,13 @@ type TopTracks struct {
 }
 
 type Tracks struct {
-	Href     string  `json:"href"`
-	Limit    int     `json:"limit"`
-	Next     string  `json:"next"`
-	Offset   int     `json:"offset"`
-	Previous string  `json:"previous"`
-	Total    int     `json:"total"`
-	Items    []Track `json:"items"`
+	Href     string               `json:"href"`
+	Limit    int                  `json:"limit"`
+	Next     string               `json:"next"`
+	Offset   int                  `json:"offset"`
+	Previous string               `json:"previous"`
+	Total    int                  `json:"total"`
+	Items    []album.SpotifyTrack `json:"items"`
 }
 
 type Restrictions struct {
@@ -118,6 +117,8 @@ type Track struct {
 	} `json:"linked_from"`
 	Restrictions Restrictions `json:"restrictions"`
 	Name         string       `json:"name"`
+	Popularity   int          `json:"popularity"`
+	ExternalIDs  ExternalIDs  `json:"external_ids"`
 	PreviewURL   string       `json:"preview_url"`
 	TrackNumber  int          `json:"track_number"`
 	Type         string       `json:"type"`
@@ -125,11 +126,6 @@ type Track struct {
 	IsLocal      bool         `json:"is_local"`
 }
 
-type Copyright struct {
-	Text string `json:"text"`
-	Type string `json:"type"`
-}
-
 type ExternalIDs struct {
 	ISRC string `json:"isrc"`
 	EAN  string `json:"ean"`
@@ -141,26 +137,26 @@ type AlbumResponses struct {
 }
 
 type AlbumResponse struct {
-	AlbumType            string       `json:"album_type"`
-	TotalTracks          int          `json:"total_tracks"`
-	AvailableMarkets     []string     `json:"available_markets"`
-	ExternalURLs         ExternalURLs `json:"external_urls"`
-	Href                 string       `json:"href"`
-	ID                   string       `json:"id"`
-	Images               []Image      `json:"images"`
-	Name                 string       `json:"name"`
-	ReleaseDate          string       `json:"release_date"`
-	ReleaseDatePrecision string       `json:"release_date_precision"`
-	Restrictions         Restrictions `json:"restrictions"`
-	Type                 string       `json:"type"`
-	URI                  string       `json:"uri"`
-	Artists              []struct{}   `json:"artists"`
-	Tracks               Tracks       `json:"tracks"`
-	Copyrights           []Copyright  `json:"copyrights"`
-	ExternalIDs          ExternalIDs  `json:"external_ids"`
-	Genres               []string     `json:"genres"`
-	Label                string       `json:"label"`
-	Popularity           int          `json:"popularity"`
+	AlbumType            string                   `json:"album_type"`
+	TotalTracks          int                      `json:"total_tracks"`
+	AvailableMarkets     []string                 `json:"available_markets"`
+	ExternalURLs         ExternalURLs             `json:"external_urls"`
+	Href                 string                   `json:"href"`
+	ID                   string                   `json:"id"`
+	Images               []Image                  `json:"images"`
+	Name                 string                   `json:"name"`
+	ReleaseDate          string                   `json:"release_date"`
+	ReleaseDatePrecision string                   `json:"release_date_precision"`
+	Restrictions         Restrictions             `json:"restrictions"`
+	Type                 string                   `json:"type"`
+	URI                  string                   `json:"uri"`
+	Artists              []struct{}               `json:"artists"`
+	Tracks               Tracks                   `json:"tracks"`
+	Copyrights           []album.SpotifyCopyright `json:"copyrights"`
+	ExternalIDs          ExternalIDs              `json:"external_ids"`
+	Genres               []string                 `json:"genres"`
+	Label                string                   `json:"label"`
+	Popularity           int                      `json:"popularity"`
 }
 
 type SearchResponse struct {
@@ -173,15 +169,37 @@ type SearchResponse struct {
 	Audiobooks interface{}         `json:"audiobooks"`
 }
 
+// retry sends the request again when Spotify rate limits us, and once more after refreshing
+// the access token when it has expired.
+func (a *Account) retry(fn func() error) error {
+	err := provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, fn)
+	if !errors.Is(err, provider.ErrUnauthorized) {
+		return err
+	}
+	refreshErr := a.GetRefreshToken()
+	if refreshErr != nil {
+		return fmt.Errorf("%w: %w", err, refreshErr)
+	}
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, fn)
+}
+
 func GetAccessToken() error {
+	return Default().GetAccessToken()
+}
+
+func GetRefreshToken() error {
+	return Default().GetRefreshToken()
+}
+
+func (a *Account) GetAccessToken() error {
 	// Authorization Code Flow https://developer.spotify.com/documentation/web-api/tutorials/code-flow
-	clientID := viper.GetString("spotify.client_id")
-	clientSecret := viper.GetString("spotify.client_secret")
-	code := viper.GetString("spotify.token")
+	clientID := a.clientID()
+	clientSecret := a.clientSecret()
+	code := a.v.GetString("spotify.token")
 
 	// If access_token && refresh_token is set then we don't need to request a new access token
-	if len(viper.GetString("spotify.access_token")) > 1 && len(viper.GetString("spotify.refresh_token")) > 1 {
-		return GetRefreshToken()
+	if len(a.v.GetString("spotify.access_token")) > 1 && len(a.v.GetString("spotify.refresh_token")) > 1 {
+		return a.GetRefreshToken()
 	}
 
 	// Build the request
@@ -212,9 +230,10 @@ func GetAccessToken() error {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	err = provider.CheckStatus(provider.Spotify, http.StatusOK, resp.StatusCode, body)
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("Authorization code Spotify")
-		return fmt.Errorf("access token Spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		return err
 	}
 
 	var authorization Authorization
@@ -223,23 +242,23 @@ func GetAccessToken() error {
 		return err
 	}
 
-	viper.Set("spotify.refresh_token", authorization.RefreshToken)
-	viper.Set("spotify.access_token", authorization.AccessToken)
+	a.v.Set("spotify.refresh_token", authorization.RefreshToken)
+	a.v.Set("spotify.access_token", authorization.AccessToken)
 
-	err = viper.WriteConfig()
+	err = a.v.WriteConfig()
 	if err != nil {
 		return err
 	}
 
-	log.Info().Msg("Spotify refresh_token and access_token has been successfully updated!")
+	log.Info().Str("account", a.Name).Msg("Spotify refresh_token and access_token has been successfully updated!")
 
 	return nil
 }
 
-func GetRefreshToken() error {
-	clientID := viper.GetString("spotify.client_id")
-	clientSecret := viper.GetString("spotify.client_secret")
-	refreshToken := viper.GetString("spotify.refresh_token")
+func (a *Account) GetRefreshToken() error {
+	clientID := a.clientID()
+	clientSecret := a.clientSecret()
+	refreshToken := a.v.GetString("spotify.refresh_token")
 
 	// Build the request
 	url := "https://accounts.spotify.com/api/token"
@@ -271,9 +290,10 @@ func GetRefreshToken() error {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	err = provider.CheckStatus(provider.Spotify, http.StatusOK, resp.StatusCode, body)
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("Refresh token Spotify")
-		return fmt.Errorf("refresh token spotify: expected 200, got %d", resp.StatusCode)
+		return err
 	}
 
 	var authorization Authorization
@@ -283,24 +303,30 @@ func GetRefreshToken() error {
 	}
 
 	if len(authorization.RefreshToken) > 1 {
-		viper.Set("spotify.refresh_token", authorization.RefreshToken)
+		a.v.Set("spotify.refresh_token", authorization.RefreshToken)
 
 	}
 	if len(authorization.AccessToken) > 1 {
-		viper.Set("spotify.access_token", authorization.AccessToken)
+		a.v.Set("spotify.access_token", authorization.AccessToken)
 	}
 
-	err = viper.WriteConfig()
+	err = a.v.WriteConfig()
 	if err != nil {
 		return err
 	}
 
-	log.Info().Msg("Spotify refresh_token and access_token has been successfully refreshed!")
+	log.Info().Str("account", a.Name).Msg("Spotify refresh_token and access_token has been successfully refreshed!")
 
 	return nil
 }
 
 func SearchAlbum(album *album.Album) error {
+	return Default().retry(func() error {
+		return searchAlbum(album)
+	})
+}
+
+func searchAlbum(album *album.Album) error {
 	log.Info().Str("platform", "Spotify").Msgf("Searching %s from %s", album.AlbumName, album.ArtistName)
 
 	baseURL := "https://api.spotify.com/v1/search"
@@ -318,7 +344,7 @@ func SearchAlbum(album *album.Album) error {
 	}
 
 	// Set the necessary headers
-	accessToken := viper.GetString("spotify.access_token")
+	accessToken := Default().accessToken()
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer "+accessToken))
 
 	// Perform the HTTP request
@@ -335,25 +361,13 @@ func SearchAlbum(album *album.Album) error {
 		return err
 	}
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		sleepingTime, err := time.ParseDuration("3s")
-		if err != nil {
-			return err
-		}
-		time.Sleep(sleepingTime)
-		return SearchAlbum(album)
-	}
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = GetRefreshToken()
-		if err != nil {
-			return nil
-		}
-		return SearchAlbum(album)
+	err = provider.CheckStatus(provider.Spotify, http.StatusOK, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrUnauthorized) {
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("GetSpotifyAlbum")
-		return fmt.Errorf("spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		return err
 	}
 
 	// Parse the JSON response
@@ -366,7 +380,7 @@ func SearchAlbum(album *album.Album) error {
 
 	// Extract the ID from the response and add it to the album
 	if searchResponse.Albums.Total == 0 {
-		return errors.New("no artist match")
+		return provider.ErrNotFound
 	}
 
 	// TODO:	There was a bug where the wrong item was matched, we need to have a smarter thing to make sure the selection is good
@@ -377,98 +391,90 @@ func SearchAlbum(album *album.Album) error {
 }
 
 func GetArtists(album *album.Album) error {
-	batchSize := 50
-	artistsLength := len(album.Spotify.Artists)
-	accessToken := viper.GetString("spotify.access_token")
-
-	log.Info().Str("platform", "Spotify").Int("nb_artists", artistsLength).Msgf("Getting artists info for %s", album.AlbumName)
-
-	for k := 0; k < artistsLength; k += batchSize {
-		end := k + batchSize
-		if end > artistsLength {
-			end = artistsLength
-		}
+	log.Info().Str("platform", "Spotify").Int("nb_artists", len(album.Spotify.Artists)).Msgf("Getting artists info for %s", album.AlbumName)
 
-		subsetArtists := album.Spotify.Artists[k:end]
+	var artistsIds []string
+	for i := 0; i < len(album.Spotify.Artists); i++ {
+		artistsIds = append(artistsIds, album.Spotify.Artists[i].Id)
+	}
 
-		var artistsIds []string
+	artists, err := Default().GetArtistsByID(artistsIds)
+	if err != nil {
+		return err
+	}
 
-		for i := 0; i < len(subsetArtists); i++ {
-			artistsIds = append(artistsIds, subsetArtists[i].Id)
+	for i := 0; i < len(artists); i++ {
+		for j := 0; j < len(album.Spotify.Artists); j++ {
+			if artists[i].ID == album.Spotify.Artists[j].Id {
+				log.Debug().Str("platform", "Spotify").Str("artist_id", album.Spotify.Artists[j].Id).Msgf("Popularity for %s: %d", album.Spotify.Artists[j].Name, artists[i].Popularity)
+				album.Spotify.Artists[j].Popularity = artists[i].Popularity
+			}
 		}
+	}
+	return nil
+}
 
-		baseURL := "https://api.spotify.com/v1/artists?ids=" + strings.Join(artistsIds, ",")
-
-		log.Debug().Str("base_url", baseURL).Strs("artists_ids", artistsIds).Msg("spotify.GetArtists")
+// GetArtistsByID fetches the full artist objects, batching the ids by 50 as allowed by /v1/artists.
+func (a *Account) GetArtistsByID(ids []string) ([]ArtistObject, error) {
+	batchSize := 50
+	var artists []ArtistObject
 
-		req, err := http.NewRequest("GET", baseURL, nil)
-		if err != nil {
-			return err
+	for k := 0; k < len(ids); k += batchSize {
+		end := k + batchSize
+		if end > len(ids) {
+			end = len(ids)
 		}
 
-		// Set the necessary headers
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		baseURL := "https://api.spotify.com/v1/artists?ids=" + strings.Join(ids[k:end], ",")
 
-		// Perform the HTTP request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
+		log.Debug().Str("base_url", baseURL).Strs("artists_ids", ids[k:end]).Msg("spotify.GetArtistsByID")
 
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
+		var artistResponses ArtistResponses
+		err := a.retry(func() error {
+			return a.getPage(baseURL, &artistResponses)
+		})
 		if err != nil {
-			return err
+			return artists, err
 		}
+		artists = append(artists, artistResponses.Artists...)
+	}
+	return artists, nil
+}
 
-		if resp.StatusCode == http.StatusTooManyRequests {
-			sleepingTime, err := time.ParseDuration("3s")
-			if err != nil {
-				return err
-			}
-			time.Sleep(sleepingTime)
-			return GetArtists(album)
-		}
+// TopTracksGenresCount counts the genres of the artists behind the top tracks. The artists
+// embedded in tracks are simplified objects without genres, so they are looked up first.
+func (a *Account) TopTracksGenresCount(topTracks []TopTrack) (map[string]int, error) {
+	seen := make(map[string]bool)
+	var artistsIds []string
 
-		if resp.StatusCode == http.StatusUnauthorized {
-			err = GetRefreshToken()
-			if err != nil {
-				return nil
+	for _, track := range topTracks {
+		for _, artist := range track.Artists {
+			if artist.ID == "" || seen[artist.ID] {
+				continue
 			}
-			return GetArtists(album)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			log.Error().Str("platform", "Spotify").Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("error during GetArtists")
-			return fmt.Errorf("spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
-		}
-
-		// Parse the JSON response
-		var artistResponses ArtistResponses
-		err = json.Unmarshal(body, &artistResponses)
-		if err != nil {
-			log.Error().Str("platform", "Spotify").Int("status_code", resp.StatusCode).Str("url", baseURL).Msg("")
-			return err
+			seen[artist.ID] = true
+			artistsIds = append(artistsIds, artist.ID)
 		}
+	}
 
-		for i := 0; i < len(artistResponses.Artists); i++ {
-			for j := 0; j < len(subsetArtists); j++ {
-				if artistResponses.Artists[i].ID == subsetArtists[j].Id {
-					log.Debug().Str("platform", "Spotify").Str("artist_id", subsetArtists[j].Id).Msgf("Popularity for %s: %d", subsetArtists[j].Name, artistResponses.Artists[i].Popularity)
-					subsetArtists[j].Popularity = artistResponses.Artists[i].Popularity
-				}
-			}
-		}
+	artists, err := a.GetArtistsByID(artistsIds)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return GenresCount(artists), nil
 }
 
 func GetAlbums(albums *[]album.Album) error {
+	return Default().retry(func() error {
+		return getAlbums(albums)
+	})
+}
+
+func getAlbums(albums *[]album.Album) error {
 	batchSize := 20
 	albumsLength := len(*albums)
-	accessToken := viper.GetString("spotify.access_token")
+	accessToken := Default().accessToken()
 
 	log.Info().Str("platform", "Spotify").Msgf("Getting albums info for %d albums", albumsLength)
 
@@ -512,25 +518,13 @@ func GetAlbums(albums *[]album.Album) error {
 			return err
 		}
 
-		if resp.StatusCode == http.StatusTooManyRequests {
-			sleepingTime, err := time.ParseDuration("3s")
-			if err != nil {
-				return err
-			}
-			time.Sleep(sleepingTime)
-			return GetAlbums(albums)
-		}
-
-		if resp.StatusCode == http.StatusUnauthorized {
-			err = GetRefreshToken()
-			if err != nil {
-				return nil
-			}
-			return GetAlbums(albums)
+		err = provider.CheckStatus(provider.Spotify, http.StatusOK, resp.StatusCode, body)
+		if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrUnauthorized) {
+			return err
 		}
-		if resp.StatusCode != http.StatusOK {
+		if err != nil {
 			log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("GetArtists")
-			return fmt.Errorf("spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
+			return err
 		}
 
 		// Parse the JSON response
@@ -549,6 +543,13 @@ func GetAlbums(albums *[]album.Album) error {
 						subsetAlbums[j].Genres = append(subsetAlbums[j].Genres, albumsResponses.Albums[i].Genres...)
 					}
 					subsetAlbums[j].Spotify.Popularity = albumsResponses.Albums[i].Popularity
+					subsetAlbums[j].Spotify.Label = albumsResponses.Albums[i].Label
+					subsetAlbums[j].Spotify.Copyrights = albumsResponses.Albums[i].Copyrights
+					subsetAlbums[j].Spotify.UPC = albumsResponses.Albums[i].ExternalIDs.UPC
+					subsetAlbums[j].Spotify.Tracks, err = albumTracks(albumsResponses.Albums[i].Tracks)
+					if err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -556,177 +557,165 @@ func GetAlbums(albums *[]album.Album) error {
 	return nil
 }
 
-func GetTopArtists() (map[string]int, error) {
-	accessToken := viper.GetString("spotify.access_token")
+// albumTracks returns the tracks of the first page along with the ones of the next pages,
+// an album only comes with its first 50 tracks.
+func albumTracks(page Tracks) ([]album.SpotifyTrack, error) {
+	tracks := page.Items
+	for page.Next != "" {
+		pageURL := page.Next
+		page = Tracks{}
+		err := Default().retry(func() error {
+			return Default().getPage(pageURL, &page)
+		})
+		if err != nil {
+			return tracks, err
+		}
+		tracks = append(tracks, page.Items...)
+	}
+	return tracks, nil
+}
+
+type TimeRange string
 
-	log.Info().Str("platform", "Spotify").Msg("Getting top artists from user")
+// Time frames over which Spotify computes the user's top items.
+const (
+	ShortTerm  TimeRange = "short_term"  // approximately last 4 weeks
+	MediumTerm TimeRange = "medium_term" // approximately last 6 months
+	LongTerm   TimeRange = "long_term"   // several years of data
+)
+
+type TopArtist struct {
+	Rank int
+	ArtistObject
+}
 
-	baseURL := "https://api.spotify.com/v1/me/top/artists"
+type TopTrack struct {
+	Rank int
+	Track
+}
+
+// getPage sends an authenticated GET for a single page of the Web API and decodes the
+// response into v, paged endpoints leaving the URL of the next page in its Next field for the
+// caller to follow. It doesn't retry: a rate limit or an expired token comes back as
+// provider.ErrRateLimited or provider.ErrUnauthorized, so wrap the call in retry.
+func (a *Account) getPage(pageURL string, v interface{}) error {
+	accessToken := a.accessToken()
 
-	log.Debug().Str("base_url", baseURL).Msg("")
+	log.Debug().Str("base_url", pageURL).Msg("")
 
-	req, err := http.NewRequest("GET", baseURL, nil)
+	req, err := http.NewRequest("GET", pageURL, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Set the necessary headers
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("limit", "50")
 
 	// Perform the HTTP request
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode == http.StatusTooManyRequests {
-		sleepingTime, err := time.ParseDuration("3s")
-		if err != nil {
-			return nil, err
-		}
-		time.Sleep(sleepingTime)
-		return GetTopArtists()
+		return err
 	}
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = GetRefreshToken()
-		if err != nil {
-			return nil, err
-		}
-		return GetTopArtists()
+	err = provider.CheckStatus(provider.Spotify, http.StatusOK, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrUnauthorized) {
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("")
-		return nil, fmt.Errorf("spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		return err
 	}
 
 	// Parse the JSON response
-	var topArtists TopArtists
-	err = json.Unmarshal(body, &topArtists)
+	err = json.Unmarshal(body, v)
 	if err != nil {
-		log.Error().Int("status_code", resp.StatusCode).Str("url", baseURL).Msg("")
-		return nil, err
-	}
-
-	var genres []string
-
-	for i := 0; i < len(topArtists.Items); i++ {
-		genres = append(genres, topArtists.Items[i].Genres...)
-	}
-
-	genresCount := make(map[string]int)
-
-	for _, genre := range genres {
-		genresCount[genre]++
-	}
-
-	keys := make([]string, 0, len(genresCount))
-
-	for key := range genresCount {
-		keys = append(keys, key)
-	}
-
-	sort.Slice(keys, func(i, j int) bool {
-		return genresCount[keys[i]] > genresCount[keys[j]]
-	})
-
-	for _, key := range keys {
-		log.Debug().Msgf("%s %d", key, genresCount[key])
+		log.Error().Int("status_code", resp.StatusCode).Str("url", pageURL).Msg("")
+		return err
 	}
-
-	return genresCount, nil
+	return nil
 }
 
-func GetTopTracks() (map[string]int, error) {
-	accessToken := viper.GetString("spotify.access_token")
-
-	log.Info().Str("platform", "Spotify").Msg("Getting top tracks from user")
-
-	baseURL := "https://api.spotify.com/v1/me/top/tracks"
-
-	log.Debug().Str("base_url", baseURL).Msg("")
-
-	req, err := http.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return nil, err
+func topItemsURL(itemType string, timeRange TimeRange) string {
+	query := url.Values{}
+	query.Set("limit", "50")
+	if timeRange != "" {
+		query.Set("time_range", string(timeRange))
 	}
+	return fmt.Sprintf("https://api.spotify.com/v1/me/top/%s?%s", itemType, query.Encode())
+}
 
-	// Set the necessary headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("limit", "50")
-
-	// Perform the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// GetTopArtists returns the user's top artists over timeRange, following every page.
+// The first artist has rank 1.
+func (a *Account) GetTopArtists(timeRange TimeRange) ([]TopArtist, error) {
+	log.Info().Str("platform", "Spotify").Str("time_range", string(timeRange)).Str("account", a.Name).Msg("Getting top artists from user")
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	var topArtists []TopArtist
+	pageURL := topItemsURL("artists", timeRange)
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		sleepingTime, err := time.ParseDuration("3s")
+	for pageURL != "" {
+		var page TopArtists
+		err := a.retry(func() error {
+			return a.getPage(pageURL, &page)
+		})
 		if err != nil {
-			return nil, err
+			return topArtists, err
 		}
-		time.Sleep(sleepingTime)
-		return GetTopTracks()
-	}
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = GetRefreshToken()
-		if err != nil {
-			return nil, err
+		for i := range page.Items {
+			topArtists = append(topArtists, TopArtist{
+				Rank:         page.Offset + i + 1,
+				ArtistObject: page.Items[i],
+			})
 		}
-		return GetTopTracks()
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("")
-		return nil, fmt.Errorf("spotify: expected %d, got %d", http.StatusOK, resp.StatusCode)
+		pageURL = page.Next
 	}
 
-	log.Debug().Bytes("body", body).Msg("")
+	return topArtists, nil
+}
 
-	// Parse the JSON response
-	var topTracks TopTracks
-	err = json.Unmarshal(body, &topTracks)
-	if err != nil {
-		log.Error().Int("status_code", resp.StatusCode).Str("url", baseURL).Msg("")
-		return nil, err
-	}
+// GetTopTracks returns the user's top tracks over timeRange, following every page.
+// The first track has rank 1.
+func (a *Account) GetTopTracks(timeRange TimeRange) ([]TopTrack, error) {
+	log.Info().Str("platform", "Spotify").Str("time_range", string(timeRange)).Str("account", a.Name).Msg("Getting top tracks from user")
 
-	var genres []string
+	var topTracks []TopTrack
+	pageURL := topItemsURL("tracks", timeRange)
 
-	for i := 0; i < len(topTracks.Items); i++ {
-		if i == 0 {
-			log.Debug().Interface("track", topTracks.Items[0]).Msg("")
-			log.Debug().Msgf("artist of track #1 %s", topTracks.Items[i].Artists[0].Name)
+	for pageURL != "" {
+		var page TopTracks
+		err := a.retry(func() error {
+			return a.getPage(pageURL, &page)
+		})
+		if err != nil {
+			return topTracks, err
 		}
-		for j := 0; j < len(topTracks.Items[i].Artists); j++ {
-			genres = append(genres, topTracks.Items[i].Artists[j].Genres...)
+		for i := range page.Items {
+			topTracks = append(topTracks, TopTrack{
+				Rank:  page.Offset + i + 1,
+				Track: page.Items[i],
+			})
 		}
+		pageURL = page.Next
 	}
 
-	log.Debug().Int("len(genres)", len(genres)).Msg("")
+	return topTracks, nil
+}
 
+// GenresCount counts how many of the given artists are tagged with each genre.
+func GenresCount(artists []ArtistObject) map[string]int {
 	genresCount := make(map[string]int)
 
-	for _, genre := range genres {
-		genresCount[genre]++
+	for _, artist := range artists {
+		for _, genre := range artist.Genres {
+			genresCount[genre]++
+		}
 	}
 
 	keys := make([]string, 0, len(genresCount))
@@ -743,5 +732,5 @@ func GetTopTracks() (map[string]int, error) {
 		log.Debug().Msgf("%s %d", key, genresCount[key])
 	}
 
-	return genresCount, nil
+	return genresCount
 }
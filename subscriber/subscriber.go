@@ -0,0 +1,226 @@
+// Package subscriber manages the people receiving the newsletter.
+package subscriber
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Channels the newsletter can be delivered on.
+const (
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelSlack    = "slack"
+	ChannelDiscord  = "discord"
+	ChannelMatrix   = "matrix"
+	ChannelTelegram = "telegram"
+)
+
+// How often a subscriber gets the newsletter.
+const (
+	Weekly   = "weekly"
+	Biweekly = "biweekly"
+	Monthly  = "monthly"
+)
+
+// Frequencies are the frequencies a subscriber can pick, the most frequent first.
+var Frequencies = []string{Weekly, Biweekly, Monthly}
+
+type Subscriber struct {
+	Name     string `mapstructure:"name" yaml:"name,omitempty"`
+	Email    string `mapstructure:"email" yaml:"email,omitempty"`
+	Timezone string `mapstructure:"timezone" yaml:"timezone,omitempty"`
+	// Platforms are the streaming platforms linked in the newsletter, all of them when empty.
+	Platforms []string `mapstructure:"platforms" yaml:"platforms,omitempty"`
+	// Genres restricts the albums to the ones tagged with one of these genres, no restriction when empty.
+	Genres []string `mapstructure:"genres" yaml:"genres,omitempty"`
+	// Profile is the file holding the subscriber's own Spotify credentials, the genres are
+	// picked from their listening history. The main account is used when empty.
+	Profile string `mapstructure:"profile" yaml:"profile,omitempty"`
+	// Theme is the name of a directory in themes_dir, the default theme when empty.
+	Theme string `mapstructure:"theme" yaml:"theme,omitempty"`
+	// Channel is where the newsletter is delivered: email, webhook, slack, discord, matrix
+	// or telegram. Email when empty.
+	Channel string `mapstructure:"channel" yaml:"channel,omitempty"`
+	// Webhook is the URL the webhook, slack and discord channels post to.
+	Webhook string `mapstructure:"webhook" yaml:"webhook,omitempty"`
+	// Room is the ID of the room the matrix channel posts to, e.g. !abc:example.org.
+	Room string `mapstructure:"room" yaml:"room,omitempty"`
+	// ChatID is the Telegram chat the telegram channel posts to, a user or a group.
+	ChatID string `mapstructure:"chat_id" yaml:"chat_id,omitempty"`
+	// Frequency is weekly, biweekly or monthly, weekly when empty.
+	Frequency string `mapstructure:"frequency" yaml:"frequency,omitempty"`
+}
+
+// Registry is the list of subscribers stored in a YAML file:
+//
+//	subscribers:
+//	  - name: Jane
+//	    email: jane@example.com
+//	    timezone: Europe/Paris
+//	    platforms: [Spotify, Deezer]
+//	    genres: [hip hop, rap]
+//	    profile: configs/profiles/jane.yaml
+//	    theme: dark
+//	    frequency: weekly
+//	  - name: Team
+//	    channel: slack
+//	    webhook: https://hooks.slack.com/services/...
+type Registry struct {
+	Subscribers []Subscriber
+	v           *viper.Viper
+}
+
+func Load(path string) (*Registry, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribers []Subscriber
+	err = v.UnmarshalKey("subscribers", &subscribers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range subscribers {
+		err = s.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Registry{Subscribers: subscribers, v: v}, nil
+}
+
+// Find returns the index of the subscriber with the given ID, see Subscriber.ID.
+func (r *Registry) Find(id string) (int, bool) {
+	for i, s := range r.Subscribers {
+		if s.ID() == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Save writes the subscribers back to the file they were loaded from, leaving out the
+// settings they don't have. The comments of the file are lost.
+func (r *Registry) Save() error {
+	r.v.Set("subscribers", r.Subscribers)
+	return r.v.WriteConfig()
+}
+
+// validate checks the subscriber has what their channel needs.
+func (s Subscriber) validate() error {
+	switch s.GetChannel() {
+	case ChannelEmail:
+		if s.Email == "" {
+			return fmt.Errorf("subscriber %q has no email", s.Name)
+		}
+	case ChannelWebhook, ChannelSlack, ChannelDiscord:
+		if s.Webhook == "" {
+			return fmt.Errorf("subscriber %q has no webhook", s.ID())
+		}
+	case ChannelMatrix:
+		if s.Room == "" {
+			return fmt.Errorf("subscriber %q has no room", s.ID())
+		}
+	case ChannelTelegram:
+		if s.ChatID == "" {
+			return fmt.Errorf("subscriber %q has no chat_id", s.ID())
+		}
+	default:
+		return fmt.Errorf("subscriber %q has an unknown channel %q", s.ID(), s.Channel)
+	}
+	if s.ID() == "" {
+		return fmt.Errorf("a %s subscriber has no name", s.GetChannel())
+	}
+	if s.Frequency != "" && !slices.Contains(Frequencies, s.Frequency) {
+		return fmt.Errorf("subscriber %q has an unknown frequency %q", s.ID(), s.Frequency)
+	}
+	return nil
+}
+
+// Due reports whether the subscriber gets the newsletter of the Friday date: every
+// week, every other week, or the first Friday of the month.
+func (s Subscriber) Due(date time.Time) bool {
+	switch s.Frequency {
+	case Biweekly:
+		// Weeks since the Unix epoch, a Thursday, so a whole Friday falls in the same one
+		// whatever its time zone
+		weeks := date.Unix() / (7 * 24 * 60 * 60)
+		return weeks%2 == 0
+	case Monthly:
+		return date.Day() <= 7
+	}
+	return true
+}
+
+// GetChannel is the channel of the subscriber, email when unset.
+func (s Subscriber) GetChannel() string {
+	if s.Channel == "" {
+		return ChannelEmail
+	}
+	return strings.ToLower(s.Channel)
+}
+
+// ID identifies the subscriber in logs and file names: their email, or their name when
+// they don't have one.
+func (s Subscriber) ID() string {
+	if s.Email != "" {
+		return s.Email
+	}
+	return s.Name
+}
+
+// Address is the subscriber formatted for the To header, the name quoted or encoded as
+// needed.
+func (s Subscriber) Address() string {
+	if s.Email == "" {
+		return ""
+	}
+	return (&mail.Address{Name: s.Name, Address: s.Email}).String()
+}
+
+// Location is the subscriber time zone, UTC when unset.
+func (s Subscriber) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+func (s Subscriber) WantsPlatform(platform string) bool {
+	if len(s.Platforms) == 0 {
+		return true
+	}
+	for _, p := range s.Platforms {
+		if strings.EqualFold(p, platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// WantsGenres reports whether an album tagged with genres matches the subscriber preferences.
+func (s Subscriber) WantsGenres(genres []string) bool {
+	if len(s.Genres) == 0 {
+		return true
+	}
+	for _, preferred := range s.Genres {
+		for _, genre := range genres {
+			if strings.EqualFold(preferred, genre) {
+				return true
+			}
+		}
+	}
+	return false
+}
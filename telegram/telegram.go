@@ -0,0 +1,205 @@
+// Package telegram is a client of the Telegram Bot API, enough to post the album cards
+// and to read the buttons pressed under them.
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"newmusicrelease/provider"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Platform names Telegram in the errors.
+const Platform = "Telegram"
+
+type Bot struct {
+	// API is the address of the Bot API, https://api.telegram.org or a local server.
+	API   string
+	Token string
+}
+
+// FromConfig returns the bot of telegram.token, talking to telegram.api.
+func FromConfig() *Bot {
+	viper.SetDefault("telegram.api", "https://api.telegram.org")
+	return &Bot{
+		API:   strings.TrimSuffix(viper.GetString("telegram.api"), "/"),
+		Token: viper.GetString("telegram.token"),
+	}
+}
+
+type User struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type Message struct {
+	MessageID int64 `json:"message_id"`
+	Chat      Chat  `json:"chat"`
+}
+
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	// Data is the CallbackData of the button pressed.
+	Data string `json:"data"`
+}
+
+type Update struct {
+	UpdateID      int64          `json:"update_id"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+// InlineButton is a button under a message, it either opens URL or sends CallbackData
+// back to the bot.
+type InlineButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type InlineKeyboard struct {
+	Rows [][]InlineButton `json:"inline_keyboard"`
+}
+
+// SendMessage are the parameters of sendMessage, see https://core.telegram.org/bots/api#sendmessage
+type SendMessage struct {
+	ChatID              string          `json:"chat_id"`
+	Text                string          `json:"text"`
+	ParseMode           string          `json:"parse_mode,omitempty"`
+	DisablePreview      bool            `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup         *InlineKeyboard `json:"reply_markup,omitempty"`
+	DisableNotification bool            `json:"disable_notification,omitempty"`
+}
+
+// SendPhoto are the parameters of sendPhoto, Photo being the URL of the image.
+type SendPhoto struct {
+	ChatID              string          `json:"chat_id"`
+	Photo               string          `json:"photo"`
+	Caption             string          `json:"caption,omitempty"`
+	ParseMode           string          `json:"parse_mode,omitempty"`
+	ReplyMarkup         *InlineKeyboard `json:"reply_markup,omitempty"`
+	DisableNotification bool            `json:"disable_notification,omitempty"`
+}
+
+// response is the envelope of every Bot API answer.
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Call sends the method with its parameters and decodes the result into v, unless v is
+// nil. It waits and tries again when Telegram asks to slow down.
+func (b *Bot) Call(method string, params any, v any) error {
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, func() error {
+		return b.call(method, params, v)
+	})
+}
+
+func (b *Bot) call(method string, params any, v any) error {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bot%s/%s", b.API, b.Token, method), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Perform the HTTP request, getUpdates holds it for up to its timeout
+	client := &http.Client{Timeout: 90 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error holds the URL, and so the token
+		return fmt.Errorf("%s: %s: %w", Platform, method, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var r response
+	err = json.Unmarshal(body, &r)
+	if err == nil && r.Parameters.RetryAfter > 0 {
+		// Telegram says how long to wait, on top of the wait between attempts
+		log.Warn().Int("retry_after", r.Parameters.RetryAfter).Str("method", method).Msg("Telegram asks to slow down")
+		time.Sleep(time.Duration(r.Parameters.RetryAfter) * time.Second)
+	}
+
+	err = provider.CheckStatus(Platform, http.StatusOK, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) {
+		return err
+	}
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Str("method", method).Str("description", r.Description).Msg("")
+		return err
+	}
+	if !r.OK {
+		return fmt.Errorf("%s: %s: %s: %w", Platform, method, r.Description, provider.ErrUpstream)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, v)
+}
+
+func (b *Bot) SendMessage(m SendMessage) error {
+	return b.Call("sendMessage", m, nil)
+}
+
+func (b *Bot) SendPhoto(p SendPhoto) error {
+	return b.Call("sendPhoto", p, nil)
+}
+
+// GetUpdates waits up to timeout for the buttons pressed since offset, the ID following
+// the last update handled.
+func (b *Bot) GetUpdates(offset int64, timeout time.Duration) ([]Update, error) {
+	params := map[string]any{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"callback_query"},
+	}
+	var updates []Update
+	err := b.Call("getUpdates", params, &updates)
+	return updates, err
+}
+
+// AnswerCallbackQuery stops the spinner of the button pressed, showing text for a moment.
+func (b *Bot) AnswerCallbackQuery(id string, text string) error {
+	return b.Call("answerCallbackQuery", map[string]string{"callback_query_id": id, "text": text}, nil)
+}
+
+// SetWebhook makes Telegram post the updates to url instead of keeping them for
+// GetUpdates. They come with secret in the X-Telegram-Bot-Api-Secret-Token header.
+func (b *Bot) SetWebhook(url string, secret string) error {
+	params := map[string]any{
+		"url":             url,
+		"secret_token":    secret,
+		"allowed_updates": []string{"callback_query"},
+	}
+	return b.Call("setWebhook", params, nil)
+}
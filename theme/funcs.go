@@ -0,0 +1,98 @@
+package theme
+
+import (
+	"fmt"
+	"html/template"
+	"newmusicrelease/album"
+	"newmusicrelease/subscriber"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Funcs are the helpers available in every template.
+func Funcs() map[string]any {
+	return map[string]any{
+		"join":           join,
+		"formatDuration": formatDuration,
+		"formatDate":     formatDate,
+		"truncate":       truncate,
+		"pluralize":      pluralize,
+		"platformIcon":   platformIcon,
+		"card":           card,
+	}
+}
+
+// Card is what the "album" template renders: the album, with the subscriber it is
+// rendered for as $.Subscriber.
+type Card struct {
+	album.Album
+	Subscriber subscriber.Subscriber
+}
+
+// card wraps an album for the "album" template: {{template "album" (card . $.Subscriber)}}.
+// Without a subscriber, every platform is linked.
+func card(a album.Album, s ...subscriber.Subscriber) Card {
+	c := Card{Album: a}
+	if len(s) > 0 {
+		c.Subscriber = s[0]
+	}
+	return c
+}
+
+// join works like strings.Join with the separator first, so it reads well in a pipeline:
+// {{.Genres | join ", "}}
+func join(sep string, elems []string) string {
+	return strings.Join(elems, sep)
+}
+
+// formatDuration renders a length such as "1 h 04 min" or "38 min", rounded to the minute.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d <= 0 {
+		return ""
+	}
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%d h %02d min", hours, minutes)
+	}
+	return fmt.Sprintf("%d min", minutes)
+}
+
+// formatDate renders t with a Go layout, "January 2, 2006" when the layout is empty.
+func formatDate(layout string, t time.Time) string {
+	if layout == "" {
+		layout = "January 2, 2006"
+	}
+	return t.Format(layout)
+}
+
+// truncate shortens s to at most n characters, ending with an ellipsis when it was cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// pluralize returns "1 album" or "3 albums". The plural is singular+"s" unless given.
+func pluralize(count int, singular string, plural ...string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, singular)
+	}
+	if len(plural) > 0 {
+		return fmt.Sprintf("%d %s", count, plural[0])
+	}
+	return fmt.Sprintf("%d %ss", count, singular)
+}
+
+// platformIcon is the cid: reference of the logo of a platform, e.g. "cid:tidal-icon-64.png".
+// It is marked as a safe URL since html/template only lets http, https and mailto through.
+func platformIcon(platform string) template.URL {
+	return template.URL(fmt.Sprintf("cid:%s-icon-64.png", strings.ToLower(platform)))
+}
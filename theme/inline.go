@@ -0,0 +1,213 @@
+package theme
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+type cssRule struct {
+	selector     string
+	specificity  [3]int
+	order        int
+	declarations []cssDeclaration
+}
+
+type cssDeclaration struct {
+	property string
+	value    string
+}
+
+// InlineCSS moves the rules of the <style> blocks into the style attribute of the elements
+// they match, since Gmail and Outlook drop the styles in <head>. What can't be inlined,
+// like @media queries or :hover, is kept in a <style> block. A style attribute already on
+// an element wins over the stylesheet.
+func InlineCSS(page []byte) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []cssRule
+	var kept []string
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		r, k := parseCSS(s.Text(), len(rules))
+		rules = append(rules, r...)
+		kept = append(kept, k...)
+	})
+	if len(rules) == 0 {
+		return page, nil
+	}
+
+	// Apply the least specific rules first so the most specific ones override them
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return less(rules[i].specificity, rules[j].specificity)
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	computed := make(map[*html.Node][]cssDeclaration)
+	var elements []*goquery.Selection
+	for _, rule := range rules {
+		doc.Find(rule.selector).Each(func(i int, s *goquery.Selection) {
+			node := s.Get(0)
+			if _, ok := computed[node]; !ok {
+				elements = append(elements, s)
+			}
+			computed[node] = merge(computed[node], rule.declarations)
+		})
+	}
+
+	for _, el := range elements {
+		declarations := computed[el.Get(0)]
+		if existing, ok := el.Attr("style"); ok {
+			declarations = merge(declarations, parseDeclarations(existing))
+		}
+		el.SetAttr("style", formatDeclarations(declarations))
+	}
+
+	doc.Find("style").Remove()
+	if len(kept) > 0 {
+		doc.Find("head").AppendHtml("<style>\n" + strings.Join(kept, "\n") + "\n</style>")
+	}
+
+	inlined, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(inlined), nil
+}
+
+// parseCSS splits a stylesheet into the rules that can be inlined, numbered from order,
+// and the blocks that have to stay in the stylesheet.
+func parseCSS(css string, order int) ([]cssRule, []string) {
+	css = cssComment.ReplaceAllString(css, "")
+
+	var rules []cssRule
+	var kept []string
+	for {
+		open := strings.Index(css, "{")
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[:open])
+
+		// Find the matching closing brace, @media blocks contain nested rules
+		depth, end := 0, -1
+		for i := open; i < len(css); i++ {
+			if css[i] == '{' {
+				depth++
+			} else if css[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		body := css[open+1 : end]
+		block := css[:end+1]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			kept = append(kept, strings.TrimSpace(block))
+			continue
+		}
+
+		declarations := parseDeclarations(body)
+		for _, selector := range strings.Split(prelude, ",") {
+			selector = strings.TrimSpace(selector)
+			_, err := cascadia.Compile(selector)
+			if err != nil || strings.Contains(selector, ":") {
+				kept = append(kept, selector+" {"+body+"}")
+				continue
+			}
+			rules = append(rules, cssRule{
+				selector:     selector,
+				specificity:  specificity(selector),
+				order:        order + len(rules),
+				declarations: declarations,
+			})
+		}
+	}
+	return rules, kept
+}
+
+func parseDeclarations(body string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range strings.Split(body, ";") {
+		property, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{property, value})
+	}
+	return declarations
+}
+
+func formatDeclarations(declarations []cssDeclaration) string {
+	parts := make([]string, len(declarations))
+	for i, d := range declarations {
+		parts[i] = d.property + ": " + d.value
+	}
+	return strings.Join(parts, "; ")
+}
+
+// merge applies overrides on top of declarations, keeping the position of the properties
+// that were already set.
+func merge(declarations []cssDeclaration, overrides []cssDeclaration) []cssDeclaration {
+	merged := append([]cssDeclaration(nil), declarations...)
+	for _, o := range overrides {
+		replaced := false
+		for i := range merged {
+			if merged[i].property == o.property {
+				merged[i].value = o.value
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+var (
+	idSelector      = regexp.MustCompile(`#[\w-]+`)
+	classSelector   = regexp.MustCompile(`\.[\w-]+|\[[^\]]*\]`)
+	elementSelector = regexp.MustCompile(`(^|[\s>+~])[a-zA-Z][\w-]*`)
+)
+
+// specificity counts the ids, classes and attributes, and element names of a selector.
+func specificity(selector string) [3]int {
+	return [3]int{
+		len(idSelector.FindAllString(selector, -1)),
+		len(classSelector.FindAllString(selector, -1)),
+		len(elementSelector.FindAllString(selector, -1)),
+	}
+}
+
+func less(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Package theme loads the look of the newsletter: a directory holding the HTML and text
+// templates, the stylesheet and the images they reference.
+//
+//	themes/<name>/
+//	    newsletter.tmpl       HTML body, required
+//	    newsletter.txt.tmpl   text alternative, generated when missing
+//	    style.css             available to the templates as {{template "style.css"}}
+//	    archive.tmpl          pages of the web archive, optional
+//	    assets/               images referenced with cid:<file name>
+package theme
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"newmusicrelease"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Default is the theme embedded in the binary, overridable with templates_dir and logos_dir.
+const Default = "default"
+
+type Theme struct {
+	Name string
+	// HTML is parsed with html/template so scraped album and artist names are escaped.
+	HTML *htmltemplate.Template
+	// Text is nil when the theme has no text template.
+	Text *template.Template
+	// Files is the theme directory, used to tell when it changed.
+	Files fs.FS
+	// Assets holds the images referenced with cid: in the templates.
+	Assets fs.FS
+}
+
+// Dir returns the files of the theme called name, looked up in themes_dir.
+func Dir(name string) fs.FS {
+	if name == "" || name == Default {
+		return newmusicrelease.Templates(viper.GetString("templates_dir"))
+	}
+	viper.SetDefault("themes_dir", "themes")
+	return os.DirFS(filepath.Join(viper.GetString("themes_dir"), name))
+}
+
+// Load parses the templates of the theme called name, the default one when empty.
+func Load(name string) (*Theme, error) {
+	if name == "" {
+		name = Default
+	}
+	files := Dir(name)
+
+	patterns := []string{"newsletter.tmpl"}
+	if _, err := fs.Stat(files, "style.css"); err == nil {
+		patterns = append(patterns, "style.css")
+	}
+	html, err := htmltemplate.New("newsletter.tmpl").Funcs(Funcs()).ParseFS(files, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := template.New("newsletter.txt.tmpl").Funcs(Funcs()).ParseFS(files, "newsletter.txt.tmpl")
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug().Str("theme", name).Msg("No text template, the text alternative will be generated")
+		text = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var assets fs.FS
+	if name == Default {
+		assets = newmusicrelease.Logos(viper.GetString("logos_dir"))
+	} else {
+		assets, err = fs.Sub(files, "assets")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Theme{Name: name, HTML: html, Text: text, Files: files, Assets: assets}, nil
+}
+
+// Archive parses archive.tmpl along with the HTML templates of the theme, which provide
+// the "album" card and the stylesheet. It has to be called before the HTML templates are
+// executed.
+func (t *Theme) Archive() (*htmltemplate.Template, error) {
+	archive, err := t.HTML.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return archive.ParseFS(t.Files, "archive.tmpl")
+}
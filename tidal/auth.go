@@ -0,0 +1,130 @@
+package tidal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"newmusicrelease/provider"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// userScopes are what the app asks the user for to manage their playlists.
+const userScopes = "user.read playlists.read playlists.write"
+
+// AuthURL is the page where the user allows the app to manage their playlists. Tidal
+// sends them back to redirectURI with a code to give to Exchange along the same verifier,
+// and with state for the caller to check,
+// see https://developer.tidal.com/documentation/api-sdk/api-sdk-authorization
+func AuthURL(redirectURI string, verifier string, state string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", viper.GetString("tidal.client_id"))
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", userScopes)
+	query.Set("state", state)
+	query.Set("code_challenge_method", "S256")
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	return "https://login.tidal.com/authorize?" + query.Encode()
+}
+
+// Exchange trades the code Tidal redirected the user with for an access and a refresh
+// token, which are saved in the config file.
+func Exchange(code string, redirectURI string, verifier string) error {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", viper.GetString("tidal.client_id"))
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+
+	err := requestUserToken(form)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("Tidal refresh_token and access_token has been successfully updated!")
+
+	return nil
+}
+
+// RefreshToken gets a new access token for the user once the previous one has expired.
+func RefreshToken() error {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", viper.GetString("tidal.client_id"))
+	form.Set("refresh_token", viper.GetString("tidal.refresh_token"))
+
+	err := requestUserToken(form)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("Tidal access_token has been successfully refreshed!")
+
+	return nil
+}
+
+func requestUserToken(form url.Values) error {
+	// Build the request
+	req, err := http.NewRequest("POST", "https://auth.tidal.com/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = provider.CheckStatus(provider.Tidal, http.StatusOK, resp.StatusCode, body)
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("User authorization Tidal")
+		return err
+	}
+
+	var auth Authorization
+	err = json.Unmarshal(body, &auth)
+	if err != nil {
+		return err
+	}
+
+	viper.Set("tidal.access_token", auth.AccessToken)
+	if auth.RefreshToken != "" {
+		viper.Set("tidal.refresh_token", auth.RefreshToken)
+	}
+	return viper.WriteConfig()
+}
+
+// retry sends a request made on behalf of the user again when Tidal rate limits us, and
+// once more after refreshing the access token when it has expired.
+func retry(fn func() error) error {
+	err := provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, fn)
+	if !errors.Is(err, provider.ErrUnauthorized) {
+		return err
+	}
+	refreshErr := RefreshToken()
+	if refreshErr != nil {
+		return fmt.Errorf("%w: %w", err, refreshErr)
+	}
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, fn)
+}
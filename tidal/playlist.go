@@ -0,0 +1,264 @@
+package tidal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"newmusicrelease/provider"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// apiURL is the root of the JSON:API flavored v2 API, which unlike the v1 search
+// works with user tokens.
+const apiURL = "https://openapi.tidal.com/v2"
+
+// playlistBatchSize is how many items /playlists/{id}/relationships/items accepts per request.
+const playlistBatchSize = 20
+
+// Resource is a JSON:API resource object, or a resource identifier when it has no attributes.
+type Resource struct {
+	ID         string         `json:"id,omitempty"`
+	Type       string         `json:"type"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Meta       map[string]any `json:"meta,omitempty"`
+}
+
+type Document struct {
+	Data Resource `json:"data"`
+}
+
+type ListDocument struct {
+	Data  []Resource `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// call sends a request authenticated with the user's access token. The payload is sent as
+// JSON when not nil, and the response is decoded into v when not nil.
+func call(method string, path string, payload any, expected int, v any) error {
+	return retry(func() error {
+		return send(method, path, payload, expected, v)
+	})
+}
+
+func send(method string, path string, payload any, expected int, v any) error {
+	var reqBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	// The next links are relative to the root of the API
+	if !strings.HasPrefix(path, apiURL) {
+		path = apiURL + path
+	}
+
+	log.Debug().Str("method", method).Str("base_url", path).Msg("")
+
+	req, err := http.NewRequest(method, path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// Set the necessary headers
+	req.Header.Set("accept", "application/vnd.api+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("tidal.access_token")))
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+
+	// Perform the HTTP request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = provider.CheckStatus(provider.Tidal, expected, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrUnauthorized) || errors.Is(err, provider.ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("")
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// TrackByISRC returns the id of the track with the given ISRC. It returns ErrNotFound when
+// Tidal doesn't have it.
+func TrackByISRC(isrc string) (string, error) {
+	query := url.Values{}
+	query.Set("countryCode", "US")
+	query.Set("filter[isrc]", isrc)
+
+	var tracks ListDocument
+	err := call("GET", "/tracks?"+query.Encode(), nil, http.StatusOK, &tracks)
+	if err != nil {
+		return "", err
+	}
+	if len(tracks.Data) == 0 {
+		return "", fmt.Errorf("%s: track %s: %w", provider.Tidal, isrc, provider.ErrNotFound)
+	}
+	return tracks.Data[0].ID, nil
+}
+
+// SyncPlaylist creates the playlist called name in the user's library, or finds it when a
+// previous run already created it, and makes its tracks the ones given. A playlist that
+// already has them is left untouched.
+func SyncPlaylist(name string, description string, public bool, trackIDs []string) (provider.Playlist, error) {
+	log.Info().Str("platform", "Tidal").Int("nb_tracks", len(trackIDs)).Msgf("Syncing the playlist %s", name)
+
+	var user Document
+	err := call("GET", "/users/me", nil, http.StatusOK, &user)
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	playlistID, err := findPlaylist(user.Data.ID, name)
+	if errors.Is(err, provider.ErrNotFound) {
+		playlistID, err = createPlaylist(name, description, public)
+	}
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	items, err := playlistItems(playlistID)
+	if err != nil {
+		return provider.Playlist{}, err
+	}
+
+	current := make([]string, len(items))
+	for i, item := range items {
+		current[i] = item.ID
+	}
+	if !slices.Equal(current, trackIDs) {
+		err = removeItems(playlistID, items)
+		if err != nil {
+			return provider.Playlist{}, err
+		}
+		err = addItems(playlistID, trackIDs)
+		if err != nil {
+			return provider.Playlist{}, err
+		}
+	}
+
+	return provider.Playlist{
+		Platform: provider.Tidal,
+		ID:       playlistID,
+		Name:     name,
+		URL:      "https://listen.tidal.com/playlist/" + playlistID,
+	}, nil
+}
+
+func findPlaylist(userID string, name string) (string, error) {
+	query := url.Values{}
+	query.Set("countryCode", "US")
+	query.Set("filter[owners.id]", userID)
+	pageURL := "/playlists?" + query.Encode()
+
+	for pageURL != "" {
+		var page ListDocument
+		err := call("GET", pageURL, nil, http.StatusOK, &page)
+		if err != nil {
+			return "", err
+		}
+		for _, playlist := range page.Data {
+			if playlist.Attributes["name"] == name {
+				return playlist.ID, nil
+			}
+		}
+		pageURL = page.Links.Next
+	}
+	return "", fmt.Errorf("%s: playlist %q: %w", provider.Tidal, name, provider.ErrNotFound)
+}
+
+func createPlaylist(name string, description string, public bool) (string, error) {
+	accessType := "UNLISTED"
+	if public {
+		accessType = "PUBLIC"
+	}
+	payload := Document{Data: Resource{
+		Type: "playlists",
+		Attributes: map[string]any{
+			"name":        name,
+			"description": description,
+			"accessType":  accessType,
+		},
+	}}
+
+	var created Document
+	err := call("POST", "/playlists?countryCode=US", payload, http.StatusCreated, &created)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().Str("platform", "Tidal").Str("playlist_id", created.Data.ID).Msgf("Created the playlist %s", name)
+	return created.Data.ID, nil
+}
+
+// playlistItems returns the tracks of the playlist, in order. Their meta holds the itemId
+// needed to remove them.
+func playlistItems(playlistID string) ([]Resource, error) {
+	var items []Resource
+	pageURL := "/playlists/" + playlistID + "/relationships/items?countryCode=US"
+
+	for pageURL != "" {
+		var page ListDocument
+		err := call("GET", pageURL, nil, http.StatusOK, &page)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, page.Data...)
+		pageURL = page.Links.Next
+	}
+	return items, nil
+}
+
+func removeItems(playlistID string, items []Resource) error {
+	for k := 0; k < len(items); k += playlistBatchSize {
+		end := min(k+playlistBatchSize, len(items))
+		payload := map[string]any{"data": items[k:end]}
+		err := call("DELETE", "/playlists/"+playlistID+"/relationships/items", payload, http.StatusNoContent, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addItems(playlistID string, trackIDs []string) error {
+	for k := 0; k < len(trackIDs); k += playlistBatchSize {
+		end := min(k+playlistBatchSize, len(trackIDs))
+		data := make([]Resource, 0, end-k)
+		for _, id := range trackIDs[k:end] {
+			data = append(data, Resource{ID: id, Type: "tracks"})
+		}
+		err := call("POST", "/playlists/"+playlistID+"/relationships/items", map[string]any{"data": data}, http.StatusNoContent, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
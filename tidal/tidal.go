@@ -9,8 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"newmusicrelease/album"
+	"newmusicrelease/provider"
 	"strings"
-	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -29,9 +29,10 @@ type SearchResponse struct {
 }
 
 type Authorization struct {
-	ExpiresIn   int    `json:"expires_in"`
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func GetAuthorization() (string, error) {
@@ -67,9 +68,10 @@ func GetAuthorization() (string, error) {
 		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	err = provider.CheckStatus(provider.Tidal, http.StatusOK, resp.StatusCode, body)
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("Authorization Tidal")
-		return "", fmt.Errorf("auth Tidal: expected 200, got %d", resp.StatusCode)
+		return "", err
 	}
 
 	var auth Authorization
@@ -82,6 +84,12 @@ func GetAuthorization() (string, error) {
 }
 
 func SearchAlbum(album *album.Album, authKey string) error {
+	return provider.RetryRateLimited(provider.RateLimitAttempts, provider.RateLimitWait, func() error {
+		return searchAlbum(album, authKey)
+	})
+}
+
+func searchAlbum(album *album.Album, authKey string) error {
 	log.Info().Str("platform", "Tidal").Msgf("Searching %s from %s", album.AlbumName, album.ArtistName)
 
 	// Build the Tidal API request URL
@@ -121,24 +129,19 @@ func SearchAlbum(album *album.Album, authKey string) error {
 		return err
 	}
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		// TODO: Check if header contains more information about Retry-After
-		// There is:
-		// X-RateLimit-Remaining		Number of tokens currently remaining. Refer to X-RateLimit-Replenish-Rate header for replenishment information. integer
-		// X-RateLimit-Burst-Capacity	Initial number of tokens, and max number of tokens that can be replenished. integer
-		// X-RateLimit-Replenish-Rate	Number of tokens replenished per second. integer
-		// X-RateLimit-Requested-Tokens	Request cost in tokens. integer
-		sleepingTime, err := time.ParseDuration("3s")
-		if err != nil {
-			return err
-		}
-		time.Sleep(sleepingTime)
-		return SearchAlbum(album, authKey)
+	// TODO: Check if header contains more information about Retry-After when rate limited
+	// There is:
+	// X-RateLimit-Remaining		Number of tokens currently remaining. Refer to X-RateLimit-Replenish-Rate header for replenishment information. integer
+	// X-RateLimit-Burst-Capacity	Initial number of tokens, and max number of tokens that can be replenished. integer
+	// X-RateLimit-Replenish-Rate	Number of tokens replenished per second. integer
+	// X-RateLimit-Requested-Tokens	Request cost in tokens. integer
+	err = provider.CheckStatus(provider.Tidal, http.StatusMultiStatus, resp.StatusCode, body)
+	if errors.Is(err, provider.ErrRateLimited) {
+		return err
 	}
-
-	if resp.StatusCode != http.StatusMultiStatus {
+	if err != nil {
 		log.Error().Int("status_code", resp.StatusCode).Stringer("url", req.URL).Bytes("body", body).Msg("Search Tidal")
-		return fmt.Errorf("search Tidal: expected %d, got %d", http.StatusMultiStatus, resp.StatusCode)
+		return err
 	}
 
 	// Parse the JSON response
@@ -151,14 +154,13 @@ func SearchAlbum(album *album.Album, authKey string) error {
 
 	// Extract the ID from the response and add it to the album
 	if searchResponse.Albums == nil || len(searchResponse.Albums) == 0 {
-		return errors.New("no album match")
+		return provider.ErrNotFound
 	}
 	if searchResponse.Albums[0].Status == http.StatusUnavailableForLegalReasons {
-		log.Warn().Int("status_code", searchResponse.Albums[0].Status).Stringer("url", req.URL).Str("album_name", album.AlbumName).Str("artist_name", album.ArtistName).Msg(searchResponse.Albums[0].Message)
 		album.Tidal.ID = searchResponse.Albums[0].ID
-	} else {
-		album.Tidal = searchResponse.Albums[0].TidalAlbum
+		return fmt.Errorf("%s: %w", searchResponse.Albums[0].Message, provider.ErrUnavailableForLegalReasons)
 	}
 
+	album.Tidal = searchResponse.Albums[0].TidalAlbum
 	return nil
 }